@@ -0,0 +1,134 @@
+package cdpproxy
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+	errForbiddenIP  = errors.New("client IP not allowed")
+)
+
+// ParseIPNets parses the comma separated IPs and CIDRs of an allowlist
+func ParseIPNets(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// AllowedIP reports whether the remote address is in the allowlist, an empty one allows all
+func AllowedIP(nets []*net.IPNet, remoteAddr string) bool {
+	if len(nets) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SecureCompare compares the secrets in constant time
+func SecureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authorize checks the client IP and the token or basic auth credentials, if required
+func (s *Server) authorize(r *http.Request) error {
+	if !AllowedIP(s.AllowedIPs, r.RemoteAddr) {
+		return errForbiddenIP
+	}
+	if s.Token == "" && s.BasicAuth == "" {
+		return nil
+	}
+
+	if s.Token != "" {
+		if t := r.URL.Query().Get("token"); t != "" && SecureCompare(t, s.Token) {
+			return nil
+		}
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") && SecureCompare(strings.TrimPrefix(h, "Bearer "), s.Token) {
+			return nil
+		}
+	}
+	if s.BasicAuth != "" {
+		if user, pass, ok := r.BasicAuth(); ok && SecureCompare(user+":"+pass, s.BasicAuth) {
+			return nil
+		}
+	}
+	return errUnauthorized
+}
+
+// authQuery carries the token the request was authorized with over to the URLs it's served,
+// as DevTools can't set the headers of its websocket
+func (s *Server) authQuery(r *http.Request) string {
+	if s.Token == "" {
+		return ""
+	}
+	if t := r.URL.Query().Get("token"); SecureCompare(t, s.Token) {
+		return "?token=" + url.QueryEscape(t)
+	}
+	return ""
+}
+
+// checkOrigin allows the websocket connections of the non-browser clients, DevTools,
+// the frontend served by the server itself and the AllowedOrigins
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil {
+		switch {
+		case u.Scheme == "devtools" || u.Scheme == "chrome-devtools":
+			return true
+		case strings.EqualFold(u.Host, r.Host):
+			return true
+		}
+	}
+	for _, o := range s.AllowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+
+	s.Logger.Warnf("auth: websocket origin not allowed: origin=%q remote=%q", origin, r.RemoteAddr)
+	return false
+}
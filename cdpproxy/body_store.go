@@ -0,0 +1,138 @@
+package cdpproxy
+
+import (
+	"bytes"
+	"sync"
+)
+
+const (
+	// DefaultMaxBodySize caps the response bodies kept, the larger ones are dropped
+	DefaultMaxBodySize = 10 << 20
+	// DefaultMaxBodiesSize caps the bodies kept by the session, the oldest ones are evicted
+	DefaultMaxBodiesSize = 100 << 20
+)
+
+// bodyStore keeps the response bodies for Network.getResponseBody within the caps:
+// the bodies over maxBody are dropped, the oldest ones are evicted to keep them all within maxTotal
+type bodyStore struct {
+	mu sync.RWMutex
+	m  map[string]*bytes.Buffer
+	// order is the keys from the oldest body to the newest one
+	order []string
+	// skipped bodies aren't kept, e.g. the tunnels' or the ones over maxBody
+	skipped map[string]bool
+	total   int
+
+	maxBody, maxTotal int
+}
+
+func newStore() *bodyStore {
+	return &bodyStore{
+		m:        make(map[string]*bytes.Buffer),
+		skipped:  make(map[string]bool),
+		maxBody:  DefaultMaxBodySize,
+		maxTotal: DefaultMaxBodiesSize,
+	}
+}
+
+// SetLimits sets the caps, the defaults if 0
+func (bs *bodyStore) SetLimits(maxBody, maxTotal int) {
+	if maxBody == 0 {
+		maxBody = DefaultMaxBodySize
+	}
+	if maxTotal == 0 {
+		maxTotal = DefaultMaxBodiesSize
+	}
+	bs.mu.Lock()
+	bs.maxBody, bs.maxTotal = maxBody, maxTotal
+	bs.mu.Unlock()
+}
+
+// Load returns a copy of the body stored under the key
+func (bs *bodyStore) Load(key string) ([]byte, bool) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	buf, ok := bs.m[key]
+	if !ok {
+		return nil, false
+	}
+	return copySlice(buf.Bytes()), true
+}
+
+// Skip drops the body stored under the key and the writes to come
+func (bs *bodyStore) Skip(key string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.skip(key)
+}
+
+func (bs *bodyStore) Write(key string, p []byte) (int, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.skipped[key] {
+		return len(p), nil
+	}
+	buf, ok := bs.m[key]
+	if !ok {
+		buf = new(bytes.Buffer)
+		bs.m[key] = buf
+		bs.order = append(bs.order, key)
+	}
+	if buf.Len()+len(p) > bs.maxBody {
+		bs.skip(key)
+		return len(p), nil
+	}
+	for bs.total+len(p) > bs.maxTotal && bs.order[0] != key {
+		bs.delete(bs.order[0])
+	}
+	if bs.total+len(p) > bs.maxTotal {
+		bs.skip(key)
+		return len(p), nil
+	}
+
+	n, err := buf.Write(p)
+	bs.total += n
+	bodyStoreBytes.Add(float64(n))
+	return n, err
+}
+
+func (bs *bodyStore) Delete(key string) {
+	bs.mu.Lock()
+	bs.delete(key)
+	delete(bs.skipped, key)
+	bs.mu.Unlock()
+}
+
+func (bs *bodyStore) Reset() {
+	bs.mu.Lock()
+	bodyStoreBytes.Add(-float64(bs.total))
+	bs.m, bs.order, bs.skipped, bs.total = make(map[string]*bytes.Buffer), nil, make(map[string]bool), 0
+	bs.mu.Unlock()
+}
+
+func (bs *bodyStore) skip(key string) {
+	bs.delete(key)
+	bs.skipped[key] = true
+}
+
+func (bs *bodyStore) delete(key string) {
+	buf, ok := bs.m[key]
+	if !ok {
+		return
+	}
+	bs.total -= buf.Len()
+	bodyStoreBytes.Add(-float64(buf.Len()))
+	delete(bs.m, key)
+	for i := range bs.order {
+		if bs.order[i] == key {
+			bs.order = append(bs.order[:i], bs.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func copySlice(p []byte) []byte {
+	dup := make([]byte, len(p))
+	copy(dup, p)
+	return dup
+}
@@ -0,0 +1,257 @@
+package cdpproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	goruntime "runtime"
+
+	"github.com/chromedp/cdproto/heapprofiler"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/performance"
+	"github.com/chromedp/cdproto/profiler"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/gorilla/websocket"
+)
+
+// devtools://devtools/bundled/inspector.html?experiments=true&v8only=true&ws=localhost:9229/cdp-proxy
+
+// https://medium.com/@paul_irish/debugging-node-js-nightlies-with-chrome-devtools-7c4a1b95ae27
+// conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"method": "Page.disable","params":{}}`)))
+func (s *Server) handleCDP(ctx context.Context, conn *wsConn, eb *EventBus, e event) error {
+	switch m := e.Method; {
+	case m == "Page.canScreencast" ||
+		m == "Network.canEmulateNetworkConditions" ||
+		m == "Emulation.canEmulate":
+		respond(conn, e.ID, `{"result":false}`)
+	case m == "Page.getResourceTree":
+		// Window decoration
+		respond(conn, e.ID,
+			`{"frameTree": { "frame":{"id":1,"url":"http://cdp-proxy","mimeType":"other"},"childFrames":[],"resources":[]}}`,
+		)
+	case m == "Network.getResponseBody":
+		params, ok := e.Params.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		e.reqID, ok = params["requestId"].(string)
+		if !ok {
+			return nil
+		}
+
+		if body, ok := eb.store.Load(e.reqID); !ok {
+			respond(conn, e.ID, `{"body":"","base64Encoded":true}`)
+		} else {
+			result := map[string]interface{}{
+				"base64Encoded": true,
+				"body":          base64.StdEncoding.Strict().EncodeToString(body),
+			}
+
+			data, err := json.Marshal(result)
+			if err != nil {
+				s.Logger.Request(e.reqID).Errorf("json.Marshal: error=%q", err)
+				return nil
+			}
+
+			// https://chromedevtools.github.io/devtools-protocol/1-2/Network#method-getResponseBody
+			respond(conn, e.ID, string(data))
+		}
+	case m == "Network.getCookies":
+		var params network.GetCookiesParams
+		if err := decodeParams(e, &params); err != nil {
+			s.Logger.Warnf("decodeParams: method=%q error=%q", m, err)
+		}
+		respondJSON(conn, e.ID, map[string]interface{}{"cookies": eb.cookies.Cookies(params.Urls)})
+	case m == "Network.getAllCookies":
+		respondJSON(conn, e.ID, map[string]interface{}{"cookies": eb.cookies.All()})
+	case m == "Network.deleteCookies":
+		var params network.DeleteCookiesParams
+		if err := decodeParams(e, &params); err != nil {
+			s.Logger.Warnf("decodeParams: method=%q error=%q", m, err)
+			respond(conn, e.ID, `{}`)
+			return nil
+		}
+		eb.cookies.Delete(params)
+		respond(conn, e.ID, `{}`)
+	case m == "Network.setCookie":
+		var params network.SetCookieParams
+		if err := decodeParams(e, &params); err != nil {
+			s.Logger.Warnf("decodeParams: method=%q error=%q", m, err)
+			respond(conn, e.ID, `{"success":false}`)
+			return nil
+		}
+		respondJSON(conn, e.ID, map[string]interface{}{"success": eb.cookies.Set(params)})
+	case m == "Runtime.enable":
+		respond(conn, e.ID, `{}`)
+		// the Console prompt needs an execution context to evaluate in
+		aux, _ := json.Marshal(map[string]interface{}{"isDefault": true, "type": "default", "frameId": "1"})
+		conn.WriteJSON(event{
+			Method: "Runtime.executionContextCreated",
+			Params: runtime.EventExecutionContextCreated{
+				Context: &runtime.ExecutionContextDescription{
+					ID:      executionContextID,
+					Origin:  "http://cdp-proxy",
+					Name:    "cdp-proxy",
+					AuxData: aux,
+				},
+			},
+		})
+	case m == "Runtime.evaluate":
+		var params runtime.EvaluateParams
+		if err := decodeParams(e, &params); err != nil {
+			s.Logger.Warnf("decodeParams: method=%q error=%q", m, err)
+		}
+		respondJSON(conn, e.ID, s.evaluate(eb, params))
+	case m == "Runtime.getProperties":
+		var params runtime.GetPropertiesParams
+		if err := decodeParams(e, &params); err != nil {
+			s.Logger.Warnf("decodeParams: method=%q error=%q", m, err)
+		}
+		props, err := s.objects.properties(params.ObjectID)
+		if err != nil {
+			respondError(conn, e.ID, err)
+			return nil
+		}
+		respondJSON(conn, e.ID, map[string]interface{}{"result": props})
+	case m == "Runtime.releaseObjectGroup":
+		var params runtime.ReleaseObjectGroupParams
+		if err := decodeParams(e, &params); err != nil {
+			s.Logger.Warnf("decodeParams: method=%q error=%q", m, err)
+		}
+		s.objects.releaseGroup(params.ObjectGroup)
+		respond(conn, e.ID, `{}`)
+	case m == "Profiler.start":
+		if err := cpuProfiles.Start(conn); err != nil {
+			respondError(conn, e.ID, err)
+			return nil
+		}
+		respond(conn, e.ID, `{}`)
+	case m == "Profiler.stop":
+		prof, err := cpuProfiles.Stop(conn, eb.clock)
+		if err != nil {
+			respondError(conn, e.ID, err)
+			return nil
+		}
+		respondJSON(conn, e.ID, profiler.StopReturns{Profile: prof})
+	case m == "HeapProfiler.startSampling":
+		base, err := heapProfile()
+		if err != nil {
+			respondError(conn, e.ID, err)
+			return nil
+		}
+		conn.heapBase = base
+		respond(conn, e.ID, `{}`)
+	case m == "HeapProfiler.getSamplingProfile" || m == "HeapProfiler.stopSampling":
+		if conn.heapBase == nil {
+			respondError(conn, e.ID, fmt.Errorf("Sampling profiler is not started"))
+			return nil
+		}
+		prof, err := heapSamplingProfile(conn.heapBase)
+		if err != nil {
+			respondError(conn, e.ID, err)
+			return nil
+		}
+		if m == "HeapProfiler.stopSampling" {
+			conn.heapBase = nil
+		}
+		respondJSON(conn, e.ID, heapprofiler.StopSamplingReturns{Profile: prof})
+	case m == "HeapProfiler.collectGarbage":
+		goruntime.GC()
+		respond(conn, e.ID, `{}`)
+	case m == "HeapProfiler.takeHeapSnapshot" || m == "HeapProfiler.startTrackingHeapObjects":
+		respondError(conn, e.ID, fmt.Errorf("%s isn't supported, use the allocation sampling", m))
+	case m == "Performance.getMetrics":
+		respondJSON(conn, e.ID, performance.GetMetricsReturns{Metrics: performanceMetrics(eb.clock)})
+	case m == "Browser.getVersion":
+		respondJSON(conn, e.ID, map[string]string{
+			"protocolVersion": protocolVersion,
+			"product":         Version,
+			"revision":        "",
+			"userAgent":       Version,
+			"jsVersion":       "",
+		})
+	case m == "Target.getTargets":
+		var infos = []targetInfo{}
+		for _, name := range s.Sessions.Names() {
+			infos = append(infos, newTargetInfo(name, name == eb.name))
+		}
+		respondJSON(conn, e.ID, map[string]interface{}{"targetInfos": infos})
+	case m == "Target.getTargetInfo":
+		var params struct {
+			TargetID string `json:"targetId"`
+		}
+		if err := decodeParams(e, &params); err != nil {
+			s.Logger.Warnf("decodeParams: method=%q error=%q", m, err)
+		}
+		if params.TargetID == "" {
+			params.TargetID = eb.name
+		}
+		if _, ok := s.Sessions.Lookup(params.TargetID); !ok {
+			respondError(conn, e.ID, fmt.Errorf("No target with given id found"))
+			return nil
+		}
+		respondJSON(conn, e.ID, map[string]interface{}{"targetInfo": newTargetInfo(params.TargetID, params.TargetID == eb.name)})
+	case m == "Target.setDiscoverTargets":
+		respond(conn, e.ID, `{}`)
+		for _, name := range s.Sessions.Names() {
+			conn.WriteJSON(event{
+				Method: "Target.targetCreated",
+				Params: map[string]interface{}{"targetInfo": newTargetInfo(name, name == eb.name)},
+			})
+		}
+	default:
+		respond(conn, e.ID, `{}`)
+	}
+
+	return nil
+}
+
+// https://chromedevtools.github.io/devtools-protocol/tot/Target/#type-TargetInfo
+type targetInfo struct {
+	TargetID string `json:"targetId"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Attached bool   `json:"attached"`
+}
+
+func newTargetInfo(name string, attached bool) targetInfo {
+	return targetInfo{TargetID: name, Type: "other", Title: name, URL: "http://cdp-proxy", Attached: attached}
+}
+
+// decodeParams decodes the event params into v
+func decodeParams(e event, v interface{}) error {
+	data, err := json.Marshal(e.Params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeConn(conn *wsConn, p []byte) (int, error) {
+	conn.logger.Local().Debugf("[CDP<-] %.120s", string(p))
+	return len(p), conn.WriteMessage(websocket.TextMessage, p)
+}
+
+func respondJSON(conn *wsConn, id int, v interface{}) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		conn.logger.Errorf("json.Marshal: error=%q", err)
+		return 0, err
+	}
+	return respond(conn, id, string(data))
+}
+
+// https://www.jsonrpc.org/specification#error_object
+func respondError(conn *wsConn, id int, err error) (int, error) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":    id,
+		"error": map[string]interface{}{"code": -32000, "message": err.Error()},
+	})
+	return writeConn(conn, data)
+}
+
+func respond(conn *wsConn, id int, p string) (int, error) {
+	return writeConn(conn, []byte(fmt.Sprintf(`{"id":%d,"result":%s}`, id, p)))
+}
@@ -0,0 +1,42 @@
+// Package cdpproxy embeds the forward proxy recording the proxied requests into the DevTools sessions,
+// and the Chrome DevTools Protocol(CDP) server DevTools inspects them with.
+//
+//	sessions := cdpproxy.NewSessions(nil)
+//	go cdpproxy.NewServer("localhost:9229", sessions).ListenAndServe(ctx)
+//	go (&cdpproxy.Proxy{Sessions: sessions}).ListenAndServe(ctx, "localhost:8080")
+//
+// Any handler, e.g. a service's own, is recorded with Handler.
+// The cdp-proxy command is built on the package the same way.
+package cdpproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+)
+
+// Tracer records the requests, e.g. Sessions or an EventBus
+type Tracer = httpx.Tracer
+
+// NewServer serves the sessions at the hostPort, see Server for the options
+func NewServer(hostPort string, sessions *Sessions) *Server {
+	return &Server{HostPort: hostPort, Sessions: sessions}
+}
+
+// Handler records the requests served by next into the tracer
+func Handler(t Tracer, next http.Handler) http.Handler {
+	return httpx.Handler(t, next)
+}
+
+// ConnContext numbers the connections served, to be set as the http.Server's ConnContext,
+// so that the requests recorded by Handler report their connection
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return httpx.ConnContext(ctx, c)
+}
+
+// Transport records the client requests sent with next, http.DefaultTransport if nil, into the tracer
+func Transport(t Tracer, next http.RoundTripper) http.RoundTripper {
+	return httpx.Transport(t, next)
+}
@@ -0,0 +1,50 @@
+package cdpproxy
+
+import (
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+// clock is the session's monotonic clock, the seconds since the session start, the events are timestamped with.
+// cdp.MonotonicTime counts from the boot time which is a wall clock reading, so the timestamps would follow
+// the wall clock adjustments; the clock measures the elapsed time with Go's monotonic readings instead,
+// so that the events' timestamps are comparable and the waterfall's durations accurate.
+type clock struct {
+	start time.Time
+}
+
+func newClock() clock {
+	return clock{start: time.Now()}
+}
+
+// since is the time elapsed from the clock's start to t
+func (c clock) since(t time.Time) time.Duration {
+	return t.Sub(c.start)
+}
+
+// timestamp is the event timestamp of t, a clock reading
+func (c clock) timestamp(t time.Time) *cdp.MonotonicTime {
+	// NOTE: marshals as the seconds since cdp.MonotonicTimeEpoch
+	mt := cdp.MonotonicTime(cdp.MonotonicTimeEpoch.Add(c.since(t)))
+	return &mt
+}
+
+// wall is the wall clock time of t on the clock: the start's wall clock time and the time elapsed since,
+// so that the times are consistent with the timestamps
+func (c clock) wall(t time.Time) time.Time {
+	return c.start.Round(0).Add(c.since(t))
+}
+
+// wallTime is the wall clock time of t, for the fields DevTools displays as dates
+func (c clock) wallTime(t time.Time) *cdp.TimeSinceEpoch {
+	wt := cdp.TimeSinceEpoch(c.wall(t))
+	return &wt
+}
+
+// consoleTime is the wall clock time of t for the Console's entries
+func (c clock) consoleTime(t time.Time) *runtime.Timestamp {
+	ts := runtime.Timestamp(c.wall(t))
+	return &ts
+}
@@ -0,0 +1,458 @@
+package cdpproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/chromedp/cdproto/runtime"
+)
+
+// Command is a function callable from the DevTools Console, ie `block("*.ads.com")`.
+// Runtime.evaluate treats console input as a single command call rather than JavaScript.
+type Command struct {
+	Usage string
+	// Pure commands have no side effects, and are safe to run in DevTools' eager evaluation
+	Pure bool
+	Run  func(args []interface{}) (interface{}, error)
+}
+
+// commands returns the builtin commands operating on the session, along with the Server's ones
+func (s *Server) commands(eb *EventBus) map[string]Command {
+	var cmds = map[string]Command{
+		"clear": {
+			Usage: "clear() forgets the recorded requests, bodies and stats",
+			Run: func(args []interface{}) (interface{}, error) {
+				eb.hist.Reset()
+				eb.store.Reset()
+				return nil, nil
+			},
+		},
+		"stats": {
+			Usage: "stats() returns the traffic stats",
+			Pure:  true,
+			Run: func(args []interface{}) (interface{}, error) {
+				return eb.hist.Stats(), nil
+			},
+		},
+		"export": {
+			Usage: `export("har") returns the recorded requests as HAR`,
+			Run: func(args []interface{}) (interface{}, error) {
+				// NOTE: the CDP clients aren't trusted with the file system, the HAR is the result to save from DevTools
+				var format string
+				if err := scanArgs(args, &format); err != nil {
+					return nil, err
+				}
+				if format != "har" {
+					return nil, fmt.Errorf("export: unsupported format %q", format)
+				}
+
+				data, err := json.MarshalIndent(eb.hist.HAR(eb.store), "", "  ")
+				if err != nil {
+					return nil, err
+				}
+				return string(data), nil
+			},
+		},
+	}
+
+	for name, c := range s.Commands {
+		cmds[name] = c
+	}
+
+	cmds["help"] = Command{
+		Usage: "help() lists the available commands",
+		Pure:  true,
+		Run: func(args []interface{}) (interface{}, error) {
+			var usage = make(map[string]string, len(cmds))
+			for name, c := range cmds {
+				usage[name] = c.Usage
+			}
+			return usage, nil
+		},
+	}
+	return cmds
+}
+
+// evaluate runs the command expression returning its result or the exception
+func (s *Server) evaluate(eb *EventBus, p runtime.EvaluateParams) runtime.EvaluateReturns {
+	var throw = func(err error) runtime.EvaluateReturns {
+		var className = "Error"
+		if _, ok := err.(syntaxError); ok {
+			className = "SyntaxError"
+		}
+		exc := &runtime.RemoteObject{
+			Type:        runtime.TypeObject,
+			Subtype:     runtime.SubtypeError,
+			ClassName:   className,
+			Description: className + ": " + err.Error(),
+		}
+		return runtime.EvaluateReturns{
+			Result: exc,
+			ExceptionDetails: &runtime.ExceptionDetails{
+				ExceptionID:        1,
+				Text:               "Uncaught",
+				Exception:          exc,
+				ExecutionContextID: executionContextID,
+			},
+		}
+	}
+
+	call, err := parseCall(p.Expression)
+	if err != nil {
+		return throw(err)
+	}
+
+	cmd, ok := s.commands(eb)[call.name]
+	if !ok {
+		return throw(fmt.Errorf("%s is not defined", call.name))
+	}
+	if !call.call {
+		return runtime.EvaluateReturns{Result: &runtime.RemoteObject{
+			Type:        runtime.TypeFunction,
+			ClassName:   "Function",
+			Description: cmd.Usage,
+		}}
+	}
+	if p.ThrowOnSideEffect && !cmd.Pure {
+		return throw(fmt.Errorf("possible side-effect in %s()", call.name))
+	}
+
+	result, err := cmd.Run(call.args)
+	if err != nil {
+		return throw(err)
+	}
+
+	obj, err := s.objects.remoteObject(result, p.ObjectGroup, p.ReturnByValue)
+	if err != nil {
+		return throw(err)
+	}
+	return runtime.EvaluateReturns{Result: obj}
+}
+
+const executionContextID runtime.ExecutionContextID = 1
+
+// remoteObjects keeps the objects returned to DevTools so they can be expanded with `Runtime.getProperties`
+type remoteObjects struct {
+	mu     sync.Mutex
+	lastID int
+	m      map[runtime.RemoteObjectID]interface{}
+	groups map[string][]runtime.RemoteObjectID
+}
+
+func newRemoteObjects() *remoteObjects {
+	return &remoteObjects{
+		m:      make(map[runtime.RemoteObjectID]interface{}),
+		groups: make(map[string][]runtime.RemoteObjectID),
+	}
+}
+
+func (ro *remoteObjects) remoteObject(v interface{}, group string, byValue bool) (*runtime.RemoteObject, error) {
+	// normalize into JSON types
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var jv interface{}
+	if err := json.Unmarshal(data, &jv); err != nil {
+		return nil, err
+	}
+
+	obj := ro.describe(jv, group)
+	if byValue && obj.Type == runtime.TypeObject {
+		obj.Value, obj.ObjectID = data, ""
+	}
+	return obj, nil
+}
+
+func (ro *remoteObjects) describe(v interface{}, group string) *runtime.RemoteObject {
+	switch vv := v.(type) {
+	case nil:
+		return &runtime.RemoteObject{Type: runtime.TypeObject, Subtype: runtime.SubtypeNull, Value: []byte("null")}
+	case string:
+		data, _ := json.Marshal(vv)
+		return &runtime.RemoteObject{Type: runtime.TypeString, Value: data}
+	case float64:
+		s := strconv.FormatFloat(vv, 'f', -1, 64)
+		return &runtime.RemoteObject{Type: runtime.TypeNumber, Value: []byte(s), Description: s}
+	case bool:
+		s := strconv.FormatBool(vv)
+		return &runtime.RemoteObject{Type: runtime.TypeBoolean, Value: []byte(s)}
+	case []interface{}:
+		return &runtime.RemoteObject{
+			Type:        runtime.TypeObject,
+			Subtype:     runtime.SubtypeArray,
+			ClassName:   "Array",
+			Description: fmt.Sprintf("Array(%d)", len(vv)),
+			ObjectID:    ro.add(vv, group),
+			Preview:     preview(vv),
+		}
+	case map[string]interface{}:
+		return &runtime.RemoteObject{
+			Type:        runtime.TypeObject,
+			ClassName:   "Object",
+			Description: "Object",
+			ObjectID:    ro.add(vv, group),
+			Preview:     preview(vv),
+		}
+	}
+	return &runtime.RemoteObject{Type: runtime.TypeUndefined}
+}
+
+func (ro *remoteObjects) add(v interface{}, group string) runtime.RemoteObjectID {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	ro.lastID++
+	id := runtime.RemoteObjectID(strconv.Itoa(ro.lastID))
+	ro.m[id] = v
+	ro.groups[group] = append(ro.groups[group], id)
+	return id
+}
+
+func (ro *remoteObjects) properties(id runtime.RemoteObjectID) ([]*runtime.PropertyDescriptor, error) {
+	ro.mu.Lock()
+	v, ok := ro.m[id]
+	group := ""
+	for g, ids := range ro.groups {
+		for _, oid := range ids {
+			if oid == id {
+				group = g
+			}
+		}
+	}
+	ro.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("could not find object with given id")
+	}
+
+	var props = []*runtime.PropertyDescriptor{}
+	for _, kv := range entries(v) {
+		props = append(props, &runtime.PropertyDescriptor{
+			Name:         kv.name,
+			Value:        ro.describe(kv.value, group),
+			Writable:     true,
+			Configurable: true,
+			Enumerable:   true,
+			IsOwn:        true,
+		})
+	}
+	return props, nil
+}
+
+func (ro *remoteObjects) releaseGroup(group string) {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	for _, id := range ro.groups[group] {
+		delete(ro.m, id)
+	}
+	delete(ro.groups, group)
+}
+
+type keyValue struct {
+	name  string
+	value interface{}
+}
+
+// entries returns the properties of a JSON object or array, in order
+func entries(v interface{}) []keyValue {
+	var kvs []keyValue
+	switch vv := v.(type) {
+	case []interface{}:
+		for i := range vv {
+			kvs = append(kvs, keyValue{strconv.Itoa(i), vv[i]})
+		}
+	case map[string]interface{}:
+		for k := range vv {
+			kvs = append(kvs, keyValue{k, vv[k]})
+		}
+		sort.Slice(kvs, func(i, k int) bool { return kvs[i].name < kvs[k].name })
+	}
+	return kvs
+}
+
+func preview(v interface{}) *runtime.ObjectPreview {
+	const max = 5
+
+	var (
+		kvs = entries(v)
+		p   = &runtime.ObjectPreview{
+			Type:       runtime.TypeObject,
+			Overflow:   len(kvs) > max,
+			Properties: []*runtime.PropertyPreview{},
+		}
+	)
+	if _, ok := v.([]interface{}); ok {
+		p.Subtype, p.Description = runtime.SubtypeArray, fmt.Sprintf("Array(%d)", len(kvs))
+	} else {
+		p.Description = "Object"
+	}
+
+	for i, kv := range kvs {
+		if i == max {
+			break
+		}
+		pp := &runtime.PropertyPreview{Name: kv.name}
+		switch vv := kv.value.(type) {
+		case nil:
+			pp.Type, pp.Subtype, pp.Value = runtime.TypeObject, runtime.SubtypeNull, "null"
+		case string:
+			pp.Type, pp.Value = runtime.TypeString, vv
+		case float64:
+			pp.Type, pp.Value = runtime.TypeNumber, strconv.FormatFloat(vv, 'f', -1, 64)
+		case bool:
+			pp.Type, pp.Value = runtime.TypeBoolean, strconv.FormatBool(vv)
+		case []interface{}:
+			pp.Type, pp.Subtype, pp.Value = runtime.TypeObject, runtime.SubtypeArray, fmt.Sprintf("Array(%d)", len(vv))
+		default:
+			pp.Type, pp.Value = runtime.TypeObject, "Object"
+		}
+		p.Properties = append(p.Properties, pp)
+	}
+	return p
+}
+
+type syntaxError string
+
+func (e syntaxError) Error() string { return string(e) }
+
+// call is a parsed command call expression: name(arg, ...)
+type call struct {
+	name string
+	call bool
+	args []interface{}
+}
+
+// parseCall parses `name[.name](arg, ...)` where args are string, number, boolean or null literals
+func parseCall(expr string) (c call, err error) {
+	var (
+		s   = strings.TrimRight(strings.TrimSpace(expr), "; \t\n")
+		pos = 0
+	)
+	var (
+		skipSpace = func() {
+			for pos < len(s) && unicode.IsSpace(rune(s[pos])) {
+				pos++
+			}
+		}
+		isIdent = func(b byte, first bool) bool {
+			return b == '_' || b == '$' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (!first && b >= '0' && b <= '9')
+		}
+		syntaxErr = func(format string, args ...interface{}) error {
+			return syntaxError(fmt.Sprintf("%s at %d", fmt.Sprintf(format, args...), pos))
+		}
+	)
+
+	// name
+	start := pos
+	for pos < len(s) && (isIdent(s[pos], pos == start || s[pos-1] == '.') || (s[pos] == '.' && pos > start)) {
+		pos++
+	}
+	if c.name = s[start:pos]; c.name == "" || strings.HasSuffix(c.name, ".") {
+		return c, syntaxErr("unexpected token")
+	}
+
+	skipSpace()
+	if pos == len(s) {
+		return c, nil
+	}
+	if s[pos] != '(' {
+		return c, syntaxErr("unexpected token %q", s[pos])
+	}
+	c.call = true
+	pos++
+
+	for {
+		skipSpace()
+		if pos == len(s) {
+			return c, syntaxErr("missing )")
+		}
+		if s[pos] == ')' {
+			pos++
+			break
+		}
+		if len(c.args) > 0 {
+			if s[pos] != ',' {
+				return c, syntaxErr("unexpected token %q", s[pos])
+			}
+			pos++
+			skipSpace()
+		}
+
+		switch b := s[pos]; {
+		case b == '"' || b == '\'':
+			end := pos + 1
+			for end < len(s) && s[end] != b {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return c, syntaxErr("unterminated string")
+			}
+			lit := s[pos+1 : end]
+			if b == '\'' {
+				lit = strings.Replace(strings.Replace(lit, `\'`, `'`, -1), `"`, `\"`, -1)
+			}
+			str, err := strconv.Unquote(`"` + lit + `"`)
+			if err != nil {
+				return c, syntaxErr("invalid string")
+			}
+			c.args = append(c.args, str)
+			pos = end + 1
+		default:
+			end := pos
+			for end < len(s) && s[end] != ',' && s[end] != ')' && !unicode.IsSpace(rune(s[end])) {
+				end++
+			}
+			switch lit := s[pos:end]; lit {
+			case "true", "false":
+				c.args = append(c.args, lit == "true")
+			case "null", "undefined":
+				c.args = append(c.args, nil)
+			default:
+				f, err := strconv.ParseFloat(lit, 64)
+				if err != nil {
+					return c, syntaxErr("unexpected token %q", lit)
+				}
+				c.args = append(c.args, f)
+			}
+			pos = end
+		}
+	}
+
+	skipSpace()
+	if pos != len(s) {
+		return c, syntaxErr("unexpected token %q", s[pos])
+	}
+	return c, nil
+}
+
+// scanArgs assigns the call args to dst pointers, optional trailing args can be omitted
+func scanArgs(args []interface{}, dst ...interface{}) error {
+	if len(args) > len(dst) {
+		return fmt.Errorf("too many arguments: got %d, want at most %d", len(args), len(dst))
+	}
+	for i, arg := range args {
+		var ok bool
+		switch d := dst[i].(type) {
+		case *string:
+			*d, ok = arg.(string)
+		case *float64:
+			*d, ok = arg.(float64)
+		case *bool:
+			*d, ok = arg.(bool)
+		}
+		if !ok {
+			return fmt.Errorf("argument %d: unexpected %T", i+1, arg)
+		}
+	}
+	return nil
+}
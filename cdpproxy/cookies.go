@@ -0,0 +1,447 @@
+package cdpproxy
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+// cookieJar keeps track of the cookies seen in the proxied traffic, keyed by domain.
+// Cookies set with `Network.setCookie` are pinned and get applied to proxied requests.
+type cookieJar struct {
+	mu sync.Mutex
+	m  map[string]map[string]*jarCookie
+}
+
+type jarCookie struct {
+	network.Cookie
+	hostOnly bool
+	pinned   bool
+}
+
+func newCookieJar() *cookieJar {
+	return &cookieJar{m: make(map[string]map[string]*jarCookie)}
+}
+
+func (j *cookieJar) store(c *jarCookie) {
+	var (
+		domain = strings.TrimPrefix(c.Domain, ".")
+		key    = c.Name + ";" + c.Domain + ";" + c.Path
+	)
+	cc, ok := j.m[domain]
+	if !ok {
+		cc = make(map[string]*jarCookie)
+		j.m[domain] = cc
+	}
+	if old, ok := cc[key]; ok && old.pinned {
+		c.pinned = true
+	}
+	if c.expired(time.Now()) {
+		delete(cc, key)
+		return
+	}
+	cc[key] = c
+}
+
+// matching calls fn on every cookie of the domain hierarchy of the host
+func (j *cookieJar) matching(host string, fn func(c *jarCookie)) {
+	for d := host; d != ""; {
+		for _, c := range j.m[d] {
+			fn(c)
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+}
+
+// Request reports the cookies sent with the request along with the stored ones which weren't sent.
+func (j *cookieJar) Request(u *url.URL, h http.Header) (sent []*network.Cookie, blocked []*network.BlockedCookieWithReason) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var (
+		host    = hostname(u)
+		now     = time.Now()
+		visited = make(map[string]bool)
+	)
+
+	for _, hc := range (&http.Request{Header: h}).Cookies() {
+		var match *jarCookie
+		j.matching(host, func(c *jarCookie) {
+			if c.Name == hc.Name && c.allows(u, now) == nil && (match == nil || len(c.Path) > len(match.Path)) {
+				match = c
+			}
+		})
+
+		if match == nil {
+			sent = append(sent, &network.Cookie{
+				Name:    hc.Name,
+				Value:   hc.Value,
+				Domain:  host,
+				Path:    "/",
+				Size:    int64(len(hc.Name) + len(hc.Value)),
+				Session: true,
+			})
+			continue
+		}
+
+		visited[match.Name] = true
+		c := match.Cookie
+		c.Value = hc.Value
+		c.Size = int64(len(c.Name) + len(c.Value))
+		sent = append(sent, &c)
+	}
+
+	j.matching(host, func(c *jarCookie) {
+		if visited[c.Name] {
+			return
+		}
+		if reasons := c.allows(u, now); len(reasons) > 0 {
+			cookie := c.Cookie
+			blocked = append(blocked, &network.BlockedCookieWithReason{
+				BlockedReasons: reasons,
+				Cookie:         &cookie,
+			})
+		}
+	})
+
+	return sent, blocked
+}
+
+// Response stores the cookies set by the response, reporting the ones that were rejected.
+func (j *cookieJar) Response(u *url.URL, h http.Header) (blocked []*network.BlockedSetCookieWithReason) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var host = hostname(u)
+
+	for _, line := range h["Set-Cookie"] {
+		hcs := (&http.Response{Header: http.Header{"Set-Cookie": {line}}}).Cookies()
+		if len(hcs) == 0 {
+			blocked = append(blocked, &network.BlockedSetCookieWithReason{
+				BlockedReasons: []network.SetCookieBlockedReason{network.SetCookieBlockedReasonSyntaxError},
+				CookieLine:     line,
+			})
+			continue
+		}
+
+		c := newJarCookie(u, hcs[0])
+		var reasons []network.SetCookieBlockedReason
+		if !c.hostOnly && !domainMatch(host, strings.TrimPrefix(c.Domain, "."), false) {
+			reasons = append(reasons, network.SetCookieBlockedReasonInvalidDomain)
+		}
+		if c.Secure && u.Scheme != "https" {
+			reasons = append(reasons, network.SetCookieBlockedReasonSecureOnly)
+		}
+		if len(reasons) > 0 {
+			blocked = append(blocked, &network.BlockedSetCookieWithReason{
+				BlockedReasons: reasons,
+				CookieLine:     line,
+				Cookie:         &c.Cookie,
+			})
+			continue
+		}
+
+		j.store(c)
+	}
+
+	return blocked
+}
+
+// Cookies returns the cookies applicable to the given URLs.
+func (j *cookieJar) Cookies(urls []string) []*network.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var (
+		now  = time.Now()
+		seen = make(map[*jarCookie]bool)
+		cc   = []*network.Cookie{}
+	)
+	for _, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil {
+			continue
+		}
+		j.matching(hostname(u), func(c *jarCookie) {
+			if seen[c] || len(c.allows(u, now)) > 0 {
+				return
+			}
+			seen[c] = true
+			cookie := c.Cookie
+			cc = append(cc, &cookie)
+		})
+	}
+	sortCookies(cc)
+	return cc
+}
+
+// All returns all the unexpired cookies.
+func (j *cookieJar) All() []*network.Cookie {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var (
+		now = time.Now()
+		cc  = []*network.Cookie{}
+	)
+	for _, m := range j.m {
+		for _, c := range m {
+			if c.expired(now) {
+				continue
+			}
+			cookie := c.Cookie
+			cc = append(cc, &cookie)
+		}
+	}
+	sortCookies(cc)
+	return cc
+}
+
+// Delete removes the cookies matching the params.
+func (j *cookieJar) Delete(p network.DeleteCookiesParams) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var u *url.URL
+	if p.URL != "" {
+		u, _ = url.Parse(p.URL)
+	}
+
+	for domain, m := range j.m {
+		for key, c := range m {
+			switch {
+			case c.Name != p.Name:
+			case p.Domain != "" && strings.TrimPrefix(p.Domain, ".") != domain:
+			case p.Path != "" && p.Path != c.Path:
+			case u != nil && len(c.allows(u, time.Now())) > 0:
+			default:
+				delete(m, key)
+			}
+		}
+		if len(m) == 0 {
+			delete(j.m, domain)
+		}
+	}
+}
+
+// Set stores a pinned cookie which gets applied to the matching proxied requests.
+func (j *cookieJar) Set(p network.SetCookieParams) bool {
+	if p.Name == "" || (p.URL == "" && p.Domain == "") {
+		return false
+	}
+
+	var c = &jarCookie{
+		Cookie: network.Cookie{
+			Name:     p.Name,
+			Value:    p.Value,
+			Path:     p.Path,
+			Secure:   p.Secure,
+			HTTPOnly: p.HTTPOnly,
+			SameSite: p.SameSite,
+			Size:     int64(len(p.Name) + len(p.Value)),
+			Session:  p.Expires == nil,
+		},
+		pinned: true,
+	}
+	if p.Expires != nil {
+		c.Expires = float64(p.Expires.Time().UnixNano()) / float64(time.Second)
+	}
+
+	if p.URL != "" {
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		c.Domain, c.hostOnly = hostname(u), true
+		if c.Path == "" {
+			c.Path = defaultPath(u)
+		}
+	}
+	if p.Domain != "" {
+		c.Domain, c.hostOnly = "."+strings.TrimPrefix(p.Domain, "."), false
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+
+	j.mu.Lock()
+	j.store(c)
+	j.mu.Unlock()
+	return true
+}
+
+// Apply adds the pinned cookies to the request, overriding the ones sent by the client.
+func (j *cookieJar) Apply(req *http.Request) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var (
+		u      = requestURL(req)
+		now    = time.Now()
+		pinned = make(map[string]string)
+	)
+	j.matching(hostname(u), func(c *jarCookie) {
+		if c.pinned && len(c.allows(u, now)) == 0 {
+			pinned[c.Name] = c.Value
+		}
+	})
+	if len(pinned) == 0 {
+		return
+	}
+
+	var cc = req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cc {
+		if v, ok := pinned[c.Name]; ok {
+			c.Value = v
+			delete(pinned, c.Name)
+		}
+		req.AddCookie(c)
+	}
+	for name, value := range pinned {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+}
+
+// CookieHandler applies the cookies set from DevTools to the requests handled by next.
+func (m *EventBus) CookieHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.cookies.Apply(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newJarCookie(u *url.URL, hc *http.Cookie) *jarCookie {
+	var c = &jarCookie{
+		Cookie: network.Cookie{
+			Name:     hc.Name,
+			Value:    hc.Value,
+			Domain:   hostname(u),
+			Path:     hc.Path,
+			Size:     int64(len(hc.Name) + len(hc.Value)),
+			HTTPOnly: hc.HttpOnly,
+			Secure:   hc.Secure,
+			Session:  true,
+		},
+		hostOnly: true,
+	}
+
+	if hc.Domain != "" {
+		c.Domain, c.hostOnly = "."+strings.TrimPrefix(strings.ToLower(hc.Domain), "."), false
+	}
+	if c.Path == "" || c.Path[0] != '/' {
+		c.Path = defaultPath(u)
+	}
+
+	switch {
+	case hc.MaxAge < 0:
+		c.Expires, c.Session = 1, false
+	case hc.MaxAge > 0:
+		c.Expires, c.Session = float64(time.Now().Unix()+int64(hc.MaxAge)), false
+	case !hc.Expires.IsZero():
+		c.Expires, c.Session = float64(hc.Expires.Unix()), false
+	}
+
+	switch hc.SameSite {
+	case http.SameSiteStrictMode:
+		c.SameSite = network.CookieSameSiteStrict
+	case http.SameSiteLaxMode:
+		c.SameSite = network.CookieSameSiteLax
+	case http.SameSiteNoneMode:
+		c.SameSite = network.CookieSameSiteNone
+	}
+
+	return c
+}
+
+func (c *jarCookie) expired(now time.Time) bool {
+	return !c.Session && c.Expires < float64(now.Unix())
+}
+
+// allows returns the reasons the cookie isn't sent to the url, if any
+func (c *jarCookie) allows(u *url.URL, now time.Time) (reasons []network.CookieBlockedReason) {
+	if c.expired(now) {
+		return []network.CookieBlockedReason{network.CookieBlockedReasonUnknownError}
+	}
+	if !domainMatch(hostname(u), strings.TrimPrefix(c.Domain, "."), c.hostOnly) {
+		reasons = append(reasons, network.CookieBlockedReasonDomainMismatch)
+	}
+	if !pathMatch(u.Path, c.Path) {
+		reasons = append(reasons, network.CookieBlockedReasonNotOnPath)
+	}
+	if c.Secure && u.Scheme != "https" {
+		reasons = append(reasons, network.CookieBlockedReasonSecureOnly)
+	}
+	return reasons
+}
+
+// https://tools.ietf.org/html/rfc6265#section-5.1.3
+func domainMatch(host, domain string, hostOnly bool) bool {
+	if host == domain {
+		return true
+	}
+	return !hostOnly && strings.HasSuffix(host, "."+domain)
+}
+
+// https://tools.ietf.org/html/rfc6265#section-5.1.4
+func pathMatch(reqPath, cookiePath string) bool {
+	if reqPath == "" {
+		reqPath = "/"
+	}
+	switch {
+	case reqPath == cookiePath:
+		return true
+	case !strings.HasPrefix(reqPath, cookiePath):
+		return false
+	case strings.HasSuffix(cookiePath, "/"):
+		return true
+	default:
+		return reqPath[len(cookiePath)] == '/'
+	}
+}
+
+// https://tools.ietf.org/html/rfc6265#section-5.1.4
+func defaultPath(u *url.URL) string {
+	if u.Path == "" || u.Path[0] != '/' || strings.Count(u.Path, "/") == 1 {
+		return "/"
+	}
+	return path.Dir(u.Path)
+}
+
+func hostname(u *url.URL) string {
+	return strings.ToLower(u.Hostname())
+}
+
+// requestURL returns the absolute URL of a request, whether it's proxied or not
+func requestURL(req *http.Request) *url.URL {
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	return &u
+}
+
+func sortCookies(cc []*network.Cookie) {
+	sort.Slice(cc, func(i, k int) bool {
+		if cc[i].Domain != cc[k].Domain {
+			return cc[i].Domain < cc[k].Domain
+		}
+		return cc[i].Name < cc[k].Name
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package cdpproxy
+
+import "time"
+
+// processCPU isn't known on the platform
+func processCPU() time.Duration {
+	return 0
+}
@@ -0,0 +1,208 @@
+package cdpproxy
+
+//go:generate go run gen_devtools.go
+
+import (
+	"archive/zip"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// devtoolsFS serves the frontend packed into devtoolsZip, nil if the binary was built without it
+var devtoolsFS = struct {
+	once sync.Once
+	fs   http.FileSystem
+}{}
+
+func devtoolsFileSystem() http.FileSystem {
+	devtoolsFS.once.Do(func() {
+		if devtoolsZip == "" {
+			return
+		}
+		zr, err := zip.NewReader(strings.NewReader(devtoolsZip), int64(len(devtoolsZip)))
+		if err != nil {
+			log.Printf("devtools: zip.NewReader: error=%q", err)
+			return
+		}
+		devtoolsFS.fs = newZipFS(zr)
+	})
+	return devtoolsFS.fs
+}
+
+// wsParam is the `ws` query param of the frontend's URL connecting it to the named session
+func (s *Server) wsParam(r *http.Request, name string) string {
+	var p = s.hostPort(r) + "/cdp"
+	if name != DefaultSession {
+		p += "/" + url.PathEscape(name)
+	}
+	return p + s.authQuery(r)
+}
+
+// serveDevtools handles `/devtools/*` with the embedded frontend.
+// `/devtools/inspector.html` without `ws` or `wss` is redirected to the default session.
+func (s *Server) serveDevtools(w http.ResponseWriter, r *http.Request) {
+	var p = strings.TrimPrefix(r.URL.Path, "/devtools")
+
+	if q := r.URL.Query(); p == "/inspector.html" && q.Get("ws") == "" && q.Get("wss") == "" {
+		_, wsScheme := schemes(r)
+		q.Set(wsScheme, s.wsParam(r, DefaultSession))
+		q.Set("experiments", "true")
+		http.Redirect(w, r, "/devtools/inspector.html?"+q.Encode(), http.StatusFound)
+		return
+	}
+
+	fs := devtoolsFileSystem()
+	if fs == nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `this binary is built without the DevTools frontend, rebuild with<br>`+
+			`<pre>go generate ./cdpproxy<br>go build -tags devtools ./main/cdp-proxy</pre>`+
+			`or open the <b>devtools://</b> link from <a href="/">the index</a> in Chrome`)
+		return
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	http.FileServer(fs).ServeHTTP(w, r2)
+}
+
+var indexTmpl = template.Must(template.New("index").Parse(`<!doctype html>
+<title>cdp-proxy</title>
+<link rel="icon" href="/favicon.ico">
+<h3>cdp-proxy sessions</h3>
+<ul>
+{{- range .Targets}}
+<li><b>{{.Title}}</b>:
+{{- if $.Embedded}} <a href="/devtools/inspector.html?experiments=true&amp;{{$.WSScheme}}={{.WS}}">open DevTools</a>,{{end}}
+ in Chrome go to <code>{{.DevtoolsFrontendURL}}</code></li>
+{{- end}}
+</ul>
+{{- if .Embedded}}
+<p><small>DevTools frontend {{.Version}}</small></p>
+{{- end}}
+`))
+
+// serveIndex lists the sessions with the links to inspect them
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	type indexTarget struct {
+		target
+		WS string
+	}
+	var (
+		data = struct {
+			Targets  []indexTarget
+			Embedded bool
+			Version  string
+			WSScheme string
+		}{
+			Embedded: devtoolsFileSystem() != nil,
+			Version:  devtoolsVersion,
+		}
+	)
+	_, data.WSScheme = schemes(r)
+	for _, name := range s.Sessions.Names() {
+		data.Targets = append(data.Targets, indexTarget{
+			target: s.target(r, name),
+			WS:     s.wsParam(r, name),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTmpl.Execute(w, data); err != nil {
+		s.Logger.Warnf("serveIndex: error=%q", err)
+	}
+}
+
+// zipFS is a read-only http.FileSystem over a zip archive
+type zipFS struct {
+	files map[string]*zip.File
+	dirs  map[string][]os.FileInfo
+}
+
+func newZipFS(zr *zip.Reader) *zipFS {
+	var fs = &zipFS{
+		files: make(map[string]*zip.File),
+		dirs:  map[string][]os.FileInfo{"/": nil},
+	}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		name := path.Clean("/" + f.Name)
+		fs.files[name] = f
+		fs.mkdirAll(path.Dir(name))
+		fs.dirs[path.Dir(name)] = append(fs.dirs[path.Dir(name)], f.FileInfo())
+	}
+	return fs
+}
+
+func (fs *zipFS) mkdirAll(dir string) {
+	if _, ok := fs.dirs[dir]; ok {
+		return
+	}
+	parent := path.Dir(dir)
+	fs.mkdirAll(parent)
+	fs.dirs[dir] = nil
+	fs.dirs[parent] = append(fs.dirs[parent], dirInfo(path.Base(dir)))
+}
+
+func dirInfo(name string) os.FileInfo {
+	return (&zip.FileHeader{Name: name + "/"}).FileInfo()
+}
+
+func (fs *zipFS) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	if f, ok := fs.files[name]; ok {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		// NOTE: http.File needs Seek, the files are small enough to be buffered
+		var b = make([]byte, f.UncompressedSize64)
+		if _, err := io.ReadFull(rc, b); err != nil {
+			return nil, err
+		}
+		return &zipFile{Reader: strings.NewReader(string(b)), fi: f.FileInfo()}, nil
+	}
+	if fis, ok := fs.dirs[name]; ok {
+		return &zipFile{
+			Reader: strings.NewReader(""),
+			fi:     dirInfo(path.Base(name)),
+			dir:    fis,
+		}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+type zipFile struct {
+	*strings.Reader
+	fi  os.FileInfo
+	dir []os.FileInfo
+}
+
+func (f *zipFile) Close() error               { return nil }
+func (f *zipFile) Stat() (os.FileInfo, error) { return f.fi, nil }
+
+func (f *zipFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.fi.IsDir() {
+		return nil, fmt.Errorf("readdir %s: not a directory", f.fi.Name())
+	}
+	var fis = f.dir
+	if count > 0 && count < len(fis) {
+		fis = fis[:count]
+	}
+	f.dir = f.dir[len(fis):]
+	return fis, nil
+}
@@ -0,0 +1,9 @@
+//go:build !devtools
+// +build !devtools
+
+package cdpproxy
+
+// built without the frontend, see gen_devtools.go
+const devtoolsVersion = ""
+
+var devtoolsZip = ""
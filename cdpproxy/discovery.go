@@ -0,0 +1,152 @@
+package cdpproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+// Version is reported by `/json/version` and `Browser.getVersion`
+var Version = "cdp-proxy/0.1"
+
+const protocolVersion = "1.3"
+
+// target describes a session as a DevTools target
+// https://chromedevtools.github.io/devtools-protocol/#endpoints
+type target struct {
+	Description          string `json:"description"`
+	DevtoolsFrontendURL  string `json:"devtoolsFrontendUrl"`
+	FaviconURL           string `json:"faviconUrl"`
+	ID                   string `json:"id"`
+	Title                string `json:"title"`
+	Type                 string `json:"type"`
+	URL                  string `json:"url"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// hostPort is the address the client reached the server at
+func (s *Server) hostPort(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return s.HostPort
+}
+
+// schemes are the http and websocket schemes the client reached the server with
+func schemes(r *http.Request) (string, string) {
+	if r.TLS != nil {
+		return "https", "wss"
+	}
+	return "http", "ws"
+}
+
+func (s *Server) target(r *http.Request, name string) target {
+	var (
+		hostPort             = s.hostPort(r)
+		wsPath               = s.wsParam(r, name)
+		httpScheme, wsScheme = schemes(r)
+	)
+	return target{
+		Description:          fmt.Sprintf("cdp-proxy requests of %q session", name),
+		DevtoolsFrontendURL:  "devtools://devtools/bundled/inspector.html?experiments=true&" + wsScheme + "=" + wsPath,
+		FaviconURL:           httpScheme + "://" + hostPort + "/favicon.ico",
+		ID:                   name,
+		Title:                name,
+		Type:                 "other",
+		URL:                  httpScheme + "://" + hostPort + "/",
+		WebSocketDebuggerURL: wsScheme + "://" + wsPath,
+	}
+}
+
+// serveDiscovery handles the `/json/*` endpoints
+// https://chromedevtools.github.io/devtools-protocol/#endpoints
+func (s *Server) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	var p = strings.TrimSuffix(r.URL.Path, "/")
+
+	switch {
+	case p == "/json" || p == "/json/list":
+		var targets = []target{}
+		for _, name := range s.Sessions.Names() {
+			targets = append(targets, s.target(r, name))
+		}
+		s.writeJSON(w, targets)
+	case p == "/json/version":
+		_, wsScheme := schemes(r)
+		s.writeJSON(w, map[string]string{
+			"Browser":              Version,
+			"Protocol-Version":     protocolVersion,
+			"User-Agent":           Version + " " + runtime.Version(),
+			"V8-Version":           "",
+			"WebKit-Version":       "",
+			"webSocketDebuggerUrl": wsScheme + "://" + s.wsParam(r, DefaultSession),
+		})
+	case p == "/json/protocol":
+		s.writeJSON(w, protocol)
+	case p == "/json/new":
+		// NOTE: PUT only, as Chrome, so that the cross-site pages can't create the sessions, e.g. with an <img src>
+		if r.Method != http.MethodPut {
+			http.Error(w, "Using unsafe HTTP verb "+r.Method+" to invoke /json/new", http.StatusMethodNotAllowed)
+			return
+		}
+		// the query is the name of the session to create, `/json/new?<name>`, the key of the requests it's to record,
+		// e.g. the port, the client IP or the user, so that DevTools can attach before they're sent
+		name, err := url.QueryUnescape(r.URL.RawQuery)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name == "" {
+			http.Error(w, "The session name is required: /json/new?<name>", http.StatusBadRequest)
+			return
+		}
+		if s.Sessions.Key == nil && name != DefaultSession {
+			http.Error(w, "The requests aren't keyed into sessions, only "+DefaultSession+" records them", http.StatusBadRequest)
+			return
+		}
+		s.Sessions.Get(name)
+		s.writeJSON(w, s.target(r, name))
+	case strings.HasPrefix(p, "/json/activate/"):
+		if _, ok := s.Sessions.Lookup(strings.TrimPrefix(p, "/json/activate/")); !ok {
+			http.Error(w, "No such target id: "+strings.TrimPrefix(p, "/json/activate/"), http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "Target activated")
+	case strings.HasPrefix(p, "/json/close/"):
+		// NOTE: PUT only as /json/new, not to be invoked cross-site
+		if r.Method != http.MethodPut {
+			http.Error(w, "Using unsafe HTTP verb "+r.Method+" to invoke /json/close", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(p, "/json/close/")
+		if name == DefaultSession || !s.Sessions.Remove(name) {
+			http.Error(w, "No such target id: "+name, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "Target is closing")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		s.Logger.Warnf("writeJSON: error=%q", err)
+	}
+}
+
+const favicon = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 16 16">` +
+	`<circle cx="8" cy="8" r="7" fill="#1a73e8"/>` +
+	`<path d="M4 8h8M9 5l3 3-3 3" stroke="#fff" stroke-width="1.5" fill="none"/></svg>`
+
+func serveFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	fmt.Fprint(w, favicon)
+}
@@ -0,0 +1,487 @@
+package cdpproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+)
+
+type event struct {
+	ID     int         `json:"id,omitempty"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+	// internal use
+	reqID string `json:"-"`
+}
+
+type eventBusReader struct {
+	ch     chan event
+	closer func() error
+	once   sync.Once
+	done   chan struct{}
+
+	// queued readers are never dropped: the events are queued for them rather than waited for,
+	// up to maxQueuedEvents, the ones beyond are dropped
+	queued bool
+	qmu    sync.Mutex
+	queue  []event
+	notify chan struct{}
+}
+
+func (r *eventBusReader) ReadEvent(ctx context.Context, e *event) error {
+	if r.queued {
+		return r.readQueued(ctx, e)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.done:
+		return io.EOF
+	case ee := <-r.ch:
+		if e == nil {
+			panic("nil destination")
+		}
+		*e = ee
+		return nil
+	}
+}
+
+func (r *eventBusReader) readQueued(ctx context.Context, e *event) error {
+	for {
+		r.qmu.Lock()
+		if len(r.queue) > 0 {
+			*e = r.queue[0]
+			r.queue[0] = event{}
+			r.queue = r.queue[1:]
+			r.qmu.Unlock()
+			return nil
+		}
+		r.qmu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.done:
+			return io.EOF
+		case <-r.notify:
+		}
+	}
+}
+
+// maxQueuedEvents bounds the events queued for a queued reader
+const maxQueuedEvents = 10000
+
+// push queues the event, reporting false if it's dropped for the queue being full
+func (r *eventBusReader) push(e event) bool {
+	r.qmu.Lock()
+	if len(r.queue) >= maxQueuedEvents {
+		r.qmu.Unlock()
+		return false
+	}
+	r.queue = append(r.queue, e)
+	r.qmu.Unlock()
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (r *eventBusReader) Close() error {
+	var err error
+	r.once.Do(func() {
+		close(r.done)
+		err = r.closer()
+	})
+	return err
+}
+
+// DefaultReaderTimeout is how long a slow reader, i.e. a CDP client, is waited for before it's dropped
+const DefaultReaderTimeout = 500 * time.Millisecond
+
+// EventBus is a DevTools session: it records the traced requests, implementing the Tracer of package http,
+// and fans their events out to the connected CDP clients
+type EventBus struct {
+	ch chan event
+
+	m struct {
+		sync.RWMutex
+		m map[*eventBusReader]struct{}
+	}
+
+	// readerTimeout is how long emit waits for a reader before dropping it
+	readerTimeout time.Duration
+
+	// id prefixes the request IDs, seq numbers the requests
+	id, seq uint64
+
+	// clock timestamps the events
+	clock clock
+	// redirects await the requests following them
+	redirects redirects
+
+	// the session state the events are recorded into
+	name    string
+	store   *bodyStore
+	cookies *cookieJar
+	hist    *history
+}
+
+// NewEventBus returns a session not added to any Sessions, e.g. to record a program's requests into it alone
+func NewEventBus() *EventBus {
+	eb := &EventBus{
+		id:      atomic.AddUint64(&busIDs, 1),
+		clock:   newClock(),
+		ch:      make(chan event, 100),
+		store:   newStore(),
+		cookies: newCookieJar(),
+		hist:    newHistory(1000),
+	}
+	eb.m.m = make(map[*eventBusReader]struct{})
+	eb.hist.onEvict = eb.store.Delete
+	return eb
+}
+
+// busIDs number the event buses
+var busIDs uint64
+
+// nextID is unique across the buses and increasing within the bus, like Chrome's `<process>.<request>` IDs
+func (eb *EventBus) nextID() string {
+	return fmt.Sprintf("%d.%d", eb.id, atomic.AddUint64(&eb.seq, 1))
+}
+
+func (eb *EventBus) addReader(r *eventBusReader) {
+	eb.m.Lock()
+	eb.m.m[r] = struct{}{}
+	eb.m.Unlock()
+}
+
+func (eb *EventBus) rmReader(r *eventBusReader) {
+	eb.m.Lock()
+	delete(eb.m.m, r)
+	eb.m.Unlock()
+}
+
+func (eb *EventBus) NewReader() *eventBusReader {
+	ebr := new(eventBusReader)
+	*ebr = eventBusReader{
+		ch:   make(chan event),
+		done: make(chan struct{}),
+		closer: func() error {
+			eb.rmReader(ebr)
+			return nil
+		},
+	}
+	eb.addReader(ebr)
+	return ebr
+}
+
+// newQueuedReader is a reader the events are queued for, never dropped for falling behind,
+// e.g. to keep a durable record of them. Once closed, it reads the events queued before it returns io.EOF.
+func (eb *EventBus) newQueuedReader() *eventBusReader {
+	ebr := eb.NewReader()
+	ebr.queued, ebr.notify = true, make(chan struct{}, 1)
+	return ebr
+}
+
+func (eb *EventBus) emit(e event) error {
+	var timeout = eb.readerTimeout
+	if timeout == 0 {
+		timeout = DefaultReaderTimeout
+	}
+	var (
+		timedOut = time.NewTimer(timeout)
+		slow     []*eventBusReader
+	)
+	defer timedOut.Stop()
+
+	eb.m.RLock()
+	for r := range eb.m.m {
+		if r.queued {
+			if !r.push(e) {
+				eventBusQueueDrops.WithLabelValues(eb.name).Inc()
+			}
+			continue
+		}
+		if !timedOut.Stop() {
+			select {
+			case <-timedOut.C:
+			default:
+			}
+		}
+		timedOut.Reset(timeout)
+		select {
+		case r.ch <- e:
+		case <-r.done:
+		case <-timedOut.C:
+			slow = append(slow, r)
+		}
+	}
+	eb.m.RUnlock()
+
+	// NOTE: closing removes the reader which needs the write lock
+	if len(slow) > 0 {
+		eventBusDrops.WithLabelValues(eb.name).Add(float64(len(slow)))
+	}
+	for _, r := range slow {
+		r.Close()
+	}
+	return nil
+}
+
+func (eb *EventBus) emitLog(le logEntry) {
+	eb.emit(logEvent(le, eb.clock))
+}
+
+// maxPostData limits the request bodies reported
+const maxPostData = 64 << 10
+
+func (m *EventBus) RequestWillBeSent(req *http.Request) (reqID string) {
+	vlog.Printf("RequestWillBeSent: %v", req)
+
+	var t = time.Now()
+
+	// the request following a redirect continues the redirected one, as in Chrome
+	var redirectResponse *network.Response
+	if re := req.Response; re != nil && re.Request != nil {
+		if prevID := httpx.RequestID(re.Request.Context()); m.redirects.follow(prevID, re.Request) {
+			reqID, redirectResponse = prevID, m.response(prevID, re)
+			m.hist.redirected(reqID)
+			m.store.Delete(reqID)
+		}
+	}
+	if reqID == "" {
+		reqID = m.nextID()
+	}
+	if req.Method == http.MethodConnect {
+		// the tunnels' bytes aren't a body to keep
+		m.store.Skip(reqID)
+	}
+
+	// the requests sent while handling a traced one are its children, loaded by the same loader as the parent
+	var (
+		parentID  = httpx.RequestID(req.Context())
+		loaderID  = reqID
+		initiator = &network.Initiator{Type: "Other"}
+	)
+	if parentID != "" {
+		loaderID = m.hist.loaderID(parentID)
+		initiator = &network.Initiator{Type: network.InitiatorTypeScript, Stack: initiatorStack()}
+	}
+	m.hist.requestWillBeSent(reqID, loaderID, req, t)
+	// the redirects this request would've followed aren't followed
+	m.redirects.release(redirectKey(reqID, loaderID, req))
+
+	var postData string
+	if req.GetBody != nil {
+		// the client requests' bodies are replayable, unlike the served ones
+		if body, err := req.GetBody(); err == nil {
+			data, _ := ioutil.ReadAll(io.LimitReader(body, maxPostData))
+			body.Close()
+			postData = string(data)
+		}
+	}
+
+	m.emit(event{
+		Method: "Network.requestWillBeSent",
+		Params: requestWillBeSent{EventRequestWillBeSent: network.EventRequestWillBeSent{
+			RequestID: network.RequestID(reqID),
+			LoaderID:  cdp.LoaderID(loaderID),
+			// TODO
+			DocumentURL: req.URL.String(),
+			// TODO:
+			// FrameID:     "123.2",
+			Request: &network.Request{
+				InitialPriority: "High",
+				ReferrerPolicy:  "no-referrer",
+				Method:          req.Method,
+				URL:             req.URL.String(),
+				Headers:         headers(req.Header),
+				PostData:        postData,
+				HasPostData:     postData != "",
+			},
+			Timestamp:        m.clock.timestamp(t),
+			WallTime:         m.clock.wallTime(t),
+			Initiator:        initiator,
+			RedirectResponse: redirectResponse,
+			Type:             "Other",
+		}, InitiatorRequestID: network.RequestID(parentID)},
+	})
+
+	sent, blocked := m.cookies.Request(requestURL(req), req.Header)
+	if blocked == nil {
+		blocked = []*network.BlockedCookieWithReason{}
+	}
+	associated := make([]*associatedCookie, 0, len(sent)+len(blocked))
+	for _, c := range sent {
+		associated = append(associated, &associatedCookie{Cookie: c, BlockedReasons: []network.CookieBlockedReason{}})
+	}
+	for _, b := range blocked {
+		associated = append(associated, &associatedCookie{Cookie: b.Cookie, BlockedReasons: b.BlockedReasons})
+	}
+
+	m.emit(event{
+		Method: "Network.requestWillBeSentExtraInfo",
+		Params: requestWillBeSentExtraInfo{
+			RequestID:         network.RequestID(reqID),
+			AssociatedCookies: associated,
+			BlockedCookies:    blocked,
+			Headers:           headers(req.Header),
+		},
+	})
+
+	return reqID
+}
+
+func (m *EventBus) ResponseReceived(reqID string, re *http.Response) {
+	vlog.Printf("ResponseReceived: reqID=%q response=%v", reqID, re)
+
+	var t = time.Now()
+	if re.StatusCode == http.StatusSwitchingProtocols {
+		// the upgraded connection is a tunnel too
+		m.store.Skip(reqID)
+	}
+	m.hist.responseReceived(reqID, re, t)
+	m.emit(event{
+		Method: "Network.responseReceived",
+		Params: network.EventResponseReceived{
+			RequestID: network.RequestID(reqID),
+			// TODO: map the document type
+			Type:      network.ResourceTypeDocument,
+			Timestamp: m.clock.timestamp(t),
+			Response:  m.response(reqID, re),
+		},
+	})
+
+	blocked := m.cookies.Response(requestURL(re.Request), re.Header)
+	if blocked == nil {
+		blocked = []*network.BlockedSetCookieWithReason{}
+	}
+	m.emit(event{
+		Method: "Network.responseReceivedExtraInfo",
+		Params: network.EventResponseReceivedExtraInfo{
+			RequestID:      network.RequestID(reqID),
+			BlockedCookies: blocked,
+			Headers:        headers(re.Header),
+		},
+	})
+}
+func (m *EventBus) DataReceived(reqID string, data []byte) {
+	vlog.Printf("DataReceived: reqID=%q data=%.10q", reqID, string(data))
+
+	var t = time.Now()
+	m.hist.dataReceived(reqID, len(data))
+	if _, err := m.store.Write(reqID, data); err != nil {
+		vlog.Printf("store.Write: reqID=%q error=%q", reqID, err)
+	}
+
+	m.emit(event{
+		Method: "Network.dataReceived",
+		Params: network.EventDataReceived{
+			RequestID:  network.RequestID(reqID),
+			Timestamp:  m.clock.timestamp(t),
+			DataLength: int64(len(data)),
+			//TODO:
+			// EncodedDataLength: int64(len(data)),
+		},
+	})
+}
+func (m *EventBus) LoadingFinished(reqID string, re *http.Response) {
+	vlog.Printf("LoadingFinished: reqID=%q", reqID)
+
+	var t = time.Now()
+	m.hist.loadingFinished(reqID, false, t)
+	// the redirects of the children aren't to be followed anymore
+	m.redirects.release("loader:" + reqID)
+	e := event{
+		Method: "Network.loadingFinished",
+		Params: network.EventLoadingFinished{
+			RequestID:         network.RequestID(reqID),
+			EncodedDataLength: float64(re.ContentLength),
+			Timestamp:         m.clock.timestamp(t),
+			// TODO:
+			// ShouldReportCorbBlocking: false,
+		},
+	}
+	if httpx.IsRedirect(re) && re.Request != nil {
+		m.redirects.finished(reqID, re.Request, redirectKey(reqID, m.hist.loaderID(reqID), re.Request), func() { m.emit(e) })
+		return
+	}
+	m.emit(e)
+}
+func (m *EventBus) LoadingFailed(reqID string, req *http.Request, err error) {
+	vlog.Printf("LoadingFailed: reqID=%q error=%q", reqID, err)
+	var t = time.Now()
+	m.hist.loadingFinished(reqID, true, t)
+	m.redirects.release("loader:" + reqID)
+	m.emit(event{
+		Method: "Network.loadingFailed",
+		Params: network.EventLoadingFailed{
+			RequestID: network.RequestID(reqID),
+			ErrorText: err.Error(),
+			Type:      "Other",
+			Canceled:  errors.Is(err, context.Canceled),
+			Timestamp: m.clock.timestamp(t),
+		},
+	})
+}
+
+// response is the request's response as reported by responseReceived and the redirects
+func (m *EventBus) response(reqID string, re *http.Response) *network.Response {
+	resp := &network.Response{
+		FromDiskCache:     false,
+		FromPrefetchCache: false,
+		Headers:           headers(re.Header),
+		RequestHeaders:    headers(re.Request.Header),
+		EncodedDataLength: float64(re.ContentLength),
+		MimeType:          "text/html", // re.Header.Get("Content-Type"),
+		URL:               re.Request.URL.String(),
+		Protocol:          re.Proto,
+		StatusText:        re.Status,
+		Status:            int64(re.StatusCode),
+	}
+	if ci, ok := httpx.Conn(re.Request.Context(), reqID); ok {
+		resp.ConnectionID, resp.ConnectionReused = float64(ci.ID), ci.Reused
+		if host, port, err := net.SplitHostPort(ci.RemoteAddr); err == nil {
+			resp.RemoteIPAddress = host
+			resp.RemotePort, _ = strconv.ParseInt(port, 10, 64)
+		}
+	}
+	return resp
+}
+
+// requestWillBeSentExtraInfo extends network.EventRequestWillBeSentExtraInfo with `associatedCookies`
+// https://chromedevtools.github.io/devtools-protocol/tot/Network/#event-requestWillBeSentExtraInfo
+type requestWillBeSentExtraInfo struct {
+	RequestID         network.RequestID                  `json:"requestId"`
+	AssociatedCookies []*associatedCookie                `json:"associatedCookies"`
+	BlockedCookies    []*network.BlockedCookieWithReason `json:"blockedCookies"`
+	Headers           network.Headers                    `json:"headers"`
+}
+
+type associatedCookie struct {
+	BlockedReasons []network.CookieBlockedReason `json:"blockedReasons"`
+	Cookie         *network.Cookie               `json:"cookie"`
+}
+
+func headers(h http.Header) network.Headers {
+	var H = make(network.Headers)
+	for k, _ := range h {
+		// TODO: all values, not just the first one
+		H[k] = h.Get(k)
+	}
+	return H
+}
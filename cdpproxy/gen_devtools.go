@@ -0,0 +1,178 @@
+//go:build ignore
+// +build ignore
+
+// gen_devtools packs the pinned DevTools frontend into devtools_assets.go
+//
+//	go generate ./cdpproxy
+//	go build -tags devtools ./main/cdp-proxy
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// the pinned chrome-devtools-frontend npm package, its front_end runs without a build step,
+// and the Subresource Integrity of its tarball, verified out of band rather than taken from the registry
+// so that a compromised registry can't swap the frontend served to DevTools
+const (
+	devtoolsFrontendVersion = "1.0.706283"
+	// NOTE: empty till pinned, gen_devtools refuses to pack an unverified tarball;
+	// `echo sha512-$(openssl dgst -sha512 -binary <tgz> | openssl base64 -A)`, the same as npm's dist.integrity
+	devtoolsFrontendIntegrity = ""
+)
+
+func main() {
+	var (
+		version   = flag.String("version", devtoolsFrontendVersion, "chrome-devtools-frontend npm package version")
+		integrity = flag.String("integrity", devtoolsFrontendIntegrity, "sha512-<base64> integrity of the version's tarball, required with -version")
+		src       = flag.String("src", "", "local package .tgz to use instead of downloading the version")
+		out       = flag.String("o", "devtools_assets.go", "output file")
+	)
+	flag.Parse()
+
+	if *version != devtoolsFrontendVersion && *integrity == devtoolsFrontendIntegrity {
+		log.Fatalf("gen_devtools: -integrity of the version %s is required", *version)
+	}
+	if *integrity == "" {
+		log.Fatalf("gen_devtools: no pinned integrity of the version %s, see devtoolsFrontendIntegrity", *version)
+	}
+
+	var (
+		tgz []byte
+		err error
+	)
+	if *src != "" {
+		tgz, err = ioutil.ReadFile(*src)
+	} else {
+		tgz, err = download(*version)
+	}
+	if err != nil {
+		log.Fatalf("gen_devtools: error=%q", err)
+	}
+	if err := verify(tgz, *integrity); err != nil {
+		log.Fatalf("gen_devtools: error=%q", err)
+	}
+
+	zipped, n, err := repack(tgz, "package/front_end/")
+	if err != nil {
+		log.Fatalf("gen_devtools: repack: error=%q", err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen_devtools.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "//go:build devtools\n// +build devtools\n\n")
+	fmt.Fprintf(&buf, "package cdpproxy\n\n")
+	fmt.Fprintf(&buf, "const devtoolsVersion = %q\n\n", *version)
+	fmt.Fprintf(&buf, "var devtoolsZip = %q\n", zipped)
+
+	if err := ioutil.WriteFile(*out, buf.Bytes(), 0644); err != nil {
+		log.Fatalf("gen_devtools: error=%q", err)
+	}
+	log.Printf("gen_devtools: version=%s files=%d size=%d output=%s", *version, n, len(zipped), *out)
+}
+
+// download fetches the package tarball
+func download(version string) ([]byte, error) {
+	var meta struct {
+		Dist struct {
+			Tarball string `json:"tarball"`
+		} `json:"dist"`
+	}
+	if err := get("https://registry.npmjs.org/chrome-devtools-frontend/"+version, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&meta)
+	}); err != nil {
+		return nil, err
+	}
+
+	var tgz []byte
+	if err := get(meta.Dist.Tarball, func(r io.Reader) (err error) {
+		tgz, err = ioutil.ReadAll(r)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return tgz, nil
+}
+
+// verify checks the tarball against the pinned integrity
+// https://w3c.github.io/webappsec-subresource-integrity/
+func verify(tgz []byte, integrity string) error {
+	sum := sha512.Sum512(tgz)
+	if got := "sha512-" + base64.StdEncoding.EncodeToString(sum[:]); got != integrity {
+		return fmt.Errorf("integrity mismatch: got=%q want=%q", got, integrity)
+	}
+	return nil
+}
+
+func get(url string, fn func(io.Reader) error) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return fn(resp.Body)
+}
+
+// repack converts the files under the prefix in the tarball into a zip archive
+func repack(tgz []byte, prefix string) ([]byte, int, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		buf bytes.Buffer
+		zw  = zip.NewWriter(&buf)
+		tr  = tar.NewReader(gz)
+		n   int
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasPrefix(hdr.Name, prefix) {
+			continue
+		}
+
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     strings.TrimPrefix(hdr.Name, prefix),
+			Method:   zip.Deflate,
+			Modified: hdr.ModTime,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return nil, 0, err
+		}
+		n++
+	}
+	if n == 0 {
+		return nil, 0, fmt.Errorf("no files under %q", prefix)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), n, nil
+}
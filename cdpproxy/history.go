@@ -0,0 +1,326 @@
+package cdpproxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/har"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+)
+
+// history keeps the last `max` requests seen on the event bus
+// along with the running totals since the start or the last reset.
+type history struct {
+	mu      sync.Mutex
+	max     int
+	order   []string
+	entries map[string]*historyEntry
+	pending map[string]bool
+	stats   Stats
+	// onEvict is called with the request ID getting evicted
+	onEvict func(reqID string)
+}
+
+type historyEntry struct {
+	reqID    string
+	loaderID string
+	req      *http.Request
+	re       *http.Response
+	started  time.Time
+	response time.Time
+	finished time.Time
+	size     int64
+	failed   bool
+	// redirected entries are the redirects followed, their bodies are gone
+	redirected bool
+}
+
+// Stats summarizes the traffic seen since the start or the last `clear()`.
+type Stats struct {
+	Since    time.Time        `json:"since"`
+	Requests int64            `json:"requests"`
+	Pending  int64            `json:"pending"`
+	Finished int64            `json:"finished"`
+	Failed   int64            `json:"failed"`
+	Bytes    int64            `json:"bytes"`
+	Methods  map[string]int64 `json:"methods"`
+	Statuses map[string]int64 `json:"statuses"`
+	Hosts    map[string]int64 `json:"hosts"`
+}
+
+func newHistory(max int) *history {
+	h := &history{max: max}
+	h.reset()
+	return h
+}
+
+func (h *history) reset() {
+	h.order = nil
+	h.entries = make(map[string]*historyEntry)
+	h.pending = make(map[string]bool)
+	h.stats = Stats{
+		Since:    time.Now(),
+		Methods:  make(map[string]int64),
+		Statuses: make(map[string]int64),
+		Hosts:    make(map[string]int64),
+	}
+}
+
+// Reset forgets all the requests and zeroes the stats.
+func (h *history) Reset() {
+	h.mu.Lock()
+	h.reset()
+	h.mu.Unlock()
+}
+
+func (h *history) requestWillBeSent(reqID, loaderID string, req *http.Request, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.order) >= h.max {
+		delete(h.entries, h.order[0])
+		if h.onEvict != nil {
+			h.onEvict(h.order[0])
+		}
+		h.order = h.order[1:]
+	}
+	h.order = append(h.order, reqID)
+	h.entries[reqID] = &historyEntry{reqID: reqID, loaderID: loaderID, req: req, started: t}
+	h.pending[reqID] = true
+
+	h.stats.Requests++
+	h.stats.Pending++
+	h.stats.Methods[req.Method]++
+	h.stats.Hosts[hostname(requestURL(req))]++
+}
+
+// redirected keeps the redirect's entry apart from the request following it with the same ID,
+// under the ID DevTools gives the redirect
+func (h *history) redirected(reqID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e, ok := h.entries[reqID]
+	if !ok {
+		return
+	}
+	var id string
+	for n := 1; ; n++ {
+		if id = fmt.Sprintf("%s:redirected.%d", reqID, n); h.entries[id] == nil {
+			break
+		}
+	}
+	delete(h.entries, reqID)
+	h.entries[id], e.redirected = e, true
+	for i := range h.order {
+		if h.order[i] == reqID {
+			h.order[i] = id
+			break
+		}
+	}
+}
+
+// loaderID is the loader of the request, the request itself if it's gone
+func (h *history) loaderID(reqID string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e, ok := h.entries[reqID]; ok {
+		return e.loaderID
+	}
+	return reqID
+}
+
+func (h *history) responseReceived(reqID string, re *http.Response, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e, ok := h.entries[reqID]; ok {
+		e.re, e.response = re, t
+	}
+	h.stats.Statuses[statusClass(re.StatusCode)]++
+}
+
+func (h *history) dataReceived(reqID string, n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e, ok := h.entries[reqID]; ok {
+		e.size += int64(n)
+	}
+	h.stats.Bytes += int64(n)
+}
+
+func (h *history) loadingFinished(reqID string, failed bool, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e, ok := h.entries[reqID]; ok {
+		e.finished, e.failed = t, failed
+	}
+	if !h.pending[reqID] {
+		return
+	}
+	delete(h.pending, reqID)
+	h.stats.Pending--
+	if failed {
+		h.stats.Failed++
+	} else {
+		h.stats.Finished++
+	}
+}
+
+// Stats returns a snapshot of the stats.
+func (h *history) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.stats
+	s.Methods, s.Statuses, s.Hosts = copyCounts(s.Methods), copyCounts(s.Statuses), copyCounts(s.Hosts)
+	return s
+}
+
+// HAR exports the recorded requests along with the bodies available in the store.
+// http://www.softwareishard.com/blog/har-12-spec/
+func (h *history) HAR(bodies *bodyStore) *har.HAR {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var entries = make([]*har.Entry, 0, len(h.order))
+	for _, reqID := range h.order {
+		e := h.entries[reqID]
+		if e.re == nil {
+			continue
+		}
+		entries = append(entries, e.har(bodies))
+	}
+
+	return &har.HAR{
+		Log: &har.Log{
+			Version: "1.2",
+			Creator: &har.Creator{Name: "cdp-proxy", Version: "0.1"},
+			Entries: entries,
+		},
+	}
+}
+
+func (e *historyEntry) har(bodies *bodyStore) *har.Entry {
+	var (
+		u       = requestURL(e.req)
+		content = &har.Content{
+			Size:     e.size,
+			MimeType: e.re.Header.Get("Content-Type"),
+		}
+		end = e.finished
+	)
+	if end.IsZero() {
+		end = e.response
+	}
+
+	if body, ok := bodies.Load(e.reqID); ok && !e.redirected {
+		if utf8.Valid(body) {
+			content.Text = string(body)
+		} else {
+			content.Text, content.Encoding = base64.StdEncoding.EncodeToString(body), "base64"
+		}
+	}
+
+	query := []*har.NameValuePair{}
+	for k, vv := range u.Query() {
+		for _, v := range vv {
+			query = append(query, &har.NameValuePair{Name: k, Value: v})
+		}
+	}
+
+	entry := &har.Entry{
+		StartedDateTime: e.started.Format(time.RFC3339Nano),
+		Time:            millis(end.Sub(e.started)),
+		Request: &har.Request{
+			Method:      e.req.Method,
+			URL:         u.String(),
+			HTTPVersion: e.req.Proto,
+			Cookies:     harCookies(e.req.Cookies()),
+			Headers:     harHeaders(e.req.Header),
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    e.req.ContentLength,
+		},
+		Response: &har.Response{
+			Status:      int64(e.re.StatusCode),
+			StatusText:  http.StatusText(e.re.StatusCode),
+			HTTPVersion: e.re.Proto,
+			Cookies:     harCookies(e.re.Cookies()),
+			Headers:     harHeaders(e.re.Header),
+			Content:     content,
+			RedirectURL: e.re.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    e.size,
+		},
+		Cache: &har.Cache{},
+		Timings: &har.Timings{
+			Send:    0,
+			Wait:    millis(e.response.Sub(e.started)),
+			Receive: millis(end.Sub(e.response)),
+		},
+	}
+	if ci, ok := httpx.Conn(e.re.Request.Context(), e.reqID); ok && ci.ID > 0 {
+		entry.Connection = strconv.FormatUint(ci.ID, 10)
+	}
+	return entry
+}
+
+func harHeaders(h http.Header) []*har.NameValuePair {
+	var nvs = []*har.NameValuePair{}
+	for k, vv := range h {
+		for _, v := range vv {
+			nvs = append(nvs, &har.NameValuePair{Name: k, Value: v})
+		}
+	}
+	sort.Slice(nvs, func(i, k int) bool { return nvs[i].Name < nvs[k].Name })
+	return nvs
+}
+
+func harCookies(cc []*http.Cookie) []*har.Cookie {
+	var hcs = []*har.Cookie{}
+	for _, c := range cc {
+		hc := &har.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			hc.Expires = c.Expires.Format(time.RFC3339)
+		}
+		hcs = append(hcs, hc)
+	}
+	return hcs
+}
+
+func statusClass(code int) string {
+	return string([]byte{byte('0' + code/100%10), 'x', 'x'})
+}
+
+func millis(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d) / float64(time.Millisecond)
+}
+
+func copyCounts(m map[string]int64) map[string]int64 {
+	mm := make(map[string]int64, len(m))
+	for k, v := range m {
+		mm[k] = v
+	}
+	return mm
+}
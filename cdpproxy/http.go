@@ -0,0 +1,367 @@
+package cdpproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/gorilla/websocket"
+
+	"github.com/gmarik/cdp-proxy/metrics"
+)
+
+var vlog = log.New(ioutil.Discard, "", log.Lshortfile)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  10 * 1024 * 1024,
+	WriteBufferSize: 25 * 1024 * 1024,
+}
+
+type eventReader interface {
+	ReadEvent(context.Context, *event) error
+	Close()
+}
+
+type entityStore interface {
+	Get(reqID string) io.Writer
+}
+
+type Server struct {
+	// Verbose is a CSV of http path prefixes to log the requests of. Default: ""
+	Verbose string
+	// Eventbus is served as the DefaultSession when Sessions isn't set
+	Eventbus *EventBus
+	// Sessions are served each at /cdp/<name>, the DefaultSession at /cdp
+	Sessions *Sessions
+	HostPort string
+	// Logger defaults to the one forwarding to Sessions
+	Logger *Logger
+	// Commands available from the DevTools Console, in addition to the builtin ones
+	Commands map[string]Command
+	// Ready reports the readiness at `/readyz`, ready if nil
+	Ready func() error
+	// Token is required as `Authorization: Bearer <token>` or the `token` query param, if set
+	Token string
+	// BasicAuth is the `user:password` required as the basic auth credentials, if set
+	BasicAuth string
+	// AllowedOrigins of the websocket connections in addition to DevTools and the server itself, "*" allows all
+	AllowedOrigins []string
+	// AllowedIPs are the only clients served, if set
+	AllowedIPs []*net.IPNet
+	// TLSConfig serves https and wss, if set
+	TLSConfig *tls.Config
+	// ReadBufferSize and WriteBufferSize of the CDP websockets, 10MiB and 25MiB if 0
+	ReadBufferSize  int
+	WriteBufferSize int
+	// ShutdownTimeout bounds draining the requests and the CDP connections once the ctx is done. Default: 5s
+	ShutdownTimeout time.Duration
+	upgrader        websocket.Upgrader
+	verboseList     []string
+	objects         *remoteObjects
+
+	connsMu sync.Mutex
+	conns   map[*wsConn]context.CancelFunc
+}
+
+func (h *Server) init() {
+	if h.Verbose != "" {
+		h.verboseList = strings.Split(h.Verbose, ",")
+	}
+	if h.Sessions == nil {
+		h.Sessions = NewSessions(nil)
+		if h.Eventbus != nil {
+			h.Sessions.Add(DefaultSession, h.Eventbus)
+		}
+	}
+	if h.Logger == nil {
+		h.Logger = NewLogger(h.Sessions)
+	}
+	h.objects = newRemoteObjects()
+	h.conns = make(map[*wsConn]context.CancelFunc)
+	h.upgrader = wsUpgrader
+	if h.ReadBufferSize > 0 {
+		h.upgrader.ReadBufferSize = h.ReadBufferSize
+	}
+	if h.WriteBufferSize > 0 {
+		h.upgrader.WriteBufferSize = h.WriteBufferSize
+	}
+	h.upgrader.CheckOrigin = h.checkOrigin
+}
+
+// ListenAndServe serves till the ctx is done, then shuts down gracefully
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	s.init()
+
+	ln, err := net.Listen("tcp", s.HostPort)
+	if err != nil {
+		return fmt.Errorf("net.Listen: %w", err)
+	}
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+
+	var srv = &http.Server{Handler: http.HandlerFunc(s.serveHTTP)}
+	// the websockets are hijacked, so untracked by the http.Server
+	srv.RegisterOnShutdown(s.closeConns)
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("http.Serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	sctx, cancel_Fn := context.WithTimeout(context.Background(), timeout)
+	defer cancel_Fn()
+
+	err = srv.Shutdown(sctx)
+	if werr := s.waitConns(sctx); err == nil {
+		err = werr
+	}
+	if err != nil {
+		srv.Close()
+		return fmt.Errorf("http.Server.Shutdown: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) trackConn(conn *wsConn, cancel_Fn context.CancelFunc) (untrack func()) {
+	s.connsMu.Lock()
+	s.conns[conn] = cancel_Fn
+	s.connsMu.Unlock()
+
+	return func() {
+		s.connsMu.Lock()
+		delete(s.conns, conn)
+		s.connsMu.Unlock()
+	}
+}
+
+// closeConns sends the close frames and stops the CDP connections
+func (s *Server) closeConns() {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for conn, cancel_Fn := range s.conns {
+		if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+			s.Logger.Warnf("closeConns: remote=%q error=%q", conn.RemoteAddr(), err)
+		}
+		cancel_Fn()
+	}
+}
+
+// waitConns waits for the CDP connections to finish, closing the remaining ones once the ctx is done
+func (s *Server) waitConns(ctx context.Context) error {
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+
+	for {
+		s.connsMu.Lock()
+		n := len(s.conns)
+		s.connsMu.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			s.connsMu.Lock()
+			for conn := range s.conns {
+				conn.Close()
+			}
+			s.connsMu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *Server) isVerbose(path string) bool {
+	for i := range h.verboseList {
+		if strings.HasPrefix(path, h.verboseList[i]) {
+			h.Logger.Debugf("OK %q: %q, %#v", path, h.verboseList[i], h.verboseList)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if u, p := r.URL, r.URL.Path; len(p) > 1 && p[0] == '/' && s.isVerbose(p[1:]) {
+		s.Logger.Infof("HTTP: start: %s", u.String())
+		defer s.Logger.Infof("HTTP: done: %s", u.String())
+	}
+
+	switch p := r.URL.Path; {
+	case p == "/healthz" || p == "/readyz" || p == "/favicon.ico" || strings.HasPrefix(p, "/devtools/"):
+		// public, the frontend is static
+	default:
+		if err := s.authorize(r); err != nil {
+			s.Logger.Warnf("auth: path=%q remote=%q error=%q", p, r.RemoteAddr, err)
+			if err == errForbiddenIP {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if s.BasicAuth != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="cdp-proxy"`)
+			}
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	switch u := r.URL; {
+	case u.Path == "/":
+		s.serveIndex(w, r)
+	case u.Path == "/devtools" || strings.HasPrefix(u.Path, "/devtools/"):
+		// the embedded DevTools frontend, see devtools.go
+		s.serveDevtools(w, r)
+	case u.Path == "/json" || strings.HasPrefix(u.Path, "/json/"):
+		// https://chromedevtools.github.io/devtools-protocol/#endpoints
+		s.serveDiscovery(w, r)
+	case u.Path == "/metrics":
+		metrics.Default.ServeHTTP(w, r)
+	case u.Path == "/healthz":
+		fmt.Fprintln(w, "ok")
+	case u.Path == "/readyz":
+		if s.Ready != nil {
+			if err := s.Ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	case u.Path == "/favicon.ico":
+		serveFavicon(w, r)
+	case u.Path == "/cdp" || strings.HasPrefix(u.Path, "/cdp/"):
+		// The endpoint, DevTools connects to to listen for the CDP events
+		// It's a bidirectional Websocket connection,
+		// which translates events from `eventReader` to CDP protocol
+		var eb *EventBus
+		if name := strings.TrimPrefix(strings.TrimPrefix(u.Path, "/cdp"), "/"); name == "" {
+			eb = s.Sessions.Get(DefaultSession)
+		} else if b, ok := s.Sessions.Lookup(name); ok {
+			eb = b
+		} else {
+			http.Error(w, fmt.Sprintf("session %q not found", name), http.StatusNotFound)
+			return
+		}
+
+		c, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			errr := fmt.Errorf("HTTP: ws.Upgrader: Upgrade: error=%q", err)
+			http.Error(w, errr.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		conn := &wsConn{Conn: c, logger: s.Logger}
+		cdpClients.WithLabelValues(eb.name).Inc()
+		defer cdpClients.WithLabelValues(eb.name).Dec()
+		ctx, cancel_Fn := context.WithCancel(r.Context())
+		defer s.trackConn(conn, cancel_Fn)()
+		defer cpuProfiles.release(conn)
+		defer conn.Close()
+		defer cancel_Fn()
+
+		if err := s.handleConn(ctx, conn, eb); err != nil && ctx.Err() == nil {
+			s.Logger.Warnf("handleConn: error=%q", err)
+		}
+	}
+}
+
+// wsConn serializes the writes as websocket.Conn supports only one concurrent writer
+type wsConn struct {
+	*websocket.Conn
+	mu sync.Mutex
+
+	// heapBase is the heap profile HeapProfiler.startSampling is called at
+	heapBase *profile.Profile
+	// logger traces the messages sent at the debug level
+	logger *Logger
+}
+
+func (c *wsConn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+func (c *wsConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
+func (s *Server) handleConn(ctx context.Context, conn *wsConn, eb *EventBus) error {
+	// NOTE: make sure to not block the goroutines to be able to errc <- err
+	var errc = make(chan error, 2)
+	go func() {
+		er := eb.NewReader()
+		defer er.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				var e event
+				if err := er.ReadEvent(ctx, &e); err != nil {
+					errc <- fmt.Errorf("eventbus.ReadEvent: %w", err)
+					return
+				}
+				// events coming from mitm proxy
+				s.Logger.Local().Debugf("[MITM->] %s", e.Method)
+
+				if err := conn.WriteJSON(e); err != nil {
+					errc <- fmt.Errorf("websocket.WriteJSON: %w", err)
+					return
+				}
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				var e event
+				if err := conn.ReadJSON(&e); err != nil {
+					errc <- fmt.Errorf("websocket.ReadJSON: %w", err)
+					return
+				}
+				s.Logger.Local().Debugf("[CDP->] %+v", e)
+				if err := s.handleCDP(ctx, conn, eb, e); err != nil {
+					errc <- fmt.Errorf("handleCDP: %w", err)
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cdpproxy
+
+import (
+	"encoding/json"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+// requestWillBeSent extends network.EventRequestWillBeSent with the initiator's `requestId`,
+// the parent request the child request is sent while handling
+// https://chromedevtools.github.io/devtools-protocol/tot/Network/#type-Initiator
+type requestWillBeSent struct {
+	network.EventRequestWillBeSent
+	InitiatorRequestID network.RequestID
+}
+
+func (e requestWillBeSent) MarshalJSON() ([]byte, error) {
+	data, err := e.EventRequestWillBeSent.MarshalJSON()
+	if err != nil || e.InitiatorRequestID == "" || e.Initiator == nil {
+		return data, err
+	}
+
+	var m, initiator map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(m["initiator"], &initiator); err != nil {
+		return nil, err
+	}
+	initiator["requestId"], _ = json.Marshal(e.InitiatorRequestID)
+	if m["initiator"], err = json.Marshal(initiator); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// maxStackFrames limits the initiator stacks
+const maxStackFrames = 32
+
+// plumbingPkgs record or send the requests, their frames are skipped off the top of the initiator stacks
+// so that the top frame is the caller's
+var plumbingPkgs = []string{
+	"github.com/gmarik/cdp-proxy/http.",
+	"github.com/gmarik/cdp-proxy/grpc.",
+	"github.com/gmarik/cdp-proxy/sql.",
+	"github.com/gmarik/cdp-proxy/cdpproxy.",
+	"net/http.",
+	"database/sql.",
+	"google.golang.org/grpc.",
+}
+
+func plumbingFrame(fn string) bool {
+	for _, pkg := range plumbingPkgs {
+		if strings.HasPrefix(fn, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// initiatorStack is the Go stack sending the request as the Script initiator's one,
+// the files as the URLs so that DevTools links the Initiator column to the caller
+func initiatorStack() *runtime.StackTrace {
+	var pcs = make([]uintptr, maxStackFrames+16)
+	// NOTE: skips Callers and initiatorStack
+	n := goruntime.Callers(2, pcs)
+	frames := goruntime.CallersFrames(pcs[:n])
+
+	var (
+		st  = &runtime.StackTrace{CallFrames: []*runtime.CallFrame{}}
+		top = true
+	)
+	for len(st.CallFrames) < maxStackFrames {
+		f, more := frames.Next()
+		if top && plumbingFrame(f.Function) && more {
+			continue
+		}
+		top = false
+		st.CallFrames = append(st.CallFrames, &runtime.CallFrame{
+			FunctionName: f.Function,
+			URL:          "file://" + f.File,
+			// 0-based
+			LineNumber: int64(f.Line - 1),
+		})
+		if !more {
+			break
+		}
+	}
+	return st
+}
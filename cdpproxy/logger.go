@@ -0,0 +1,164 @@
+package cdpproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+// Logger writes leveled logs to the standard logger
+// and forwards the ones at the level set or above to the DevTools Console:
+// the request's entries as `Log.entryAdded` events linked to the request, the rest as `Runtime.consoleAPICalled`.
+// The CDP traffic is logged with a Local logger, not to feed the event stream back into itself.
+type Logger struct {
+	std   *log.Logger
+	min   int
+	ch    chan logEntry
+	reqID string
+}
+
+// logSink is either an event bus or Sessions
+type logSink interface {
+	emitLog(le logEntry)
+}
+
+// logEntry is the entry forwarded, the sink turns it into the Console's event
+type logEntry struct {
+	reqID string
+	level cdplog.Level
+	text  string
+	t     time.Time
+}
+
+// NewLogger returns a Logger forwarding to the sink, if not nil.
+func NewLogger(sink logSink) *Logger {
+	l := &Logger{
+		std: log.New(os.Stderr, "", log.LstdFlags),
+	}
+	if sink == nil {
+		return l
+	}
+
+	// NOTE: forwarding is asynchronous so logging from an event bus reader doesn't block on itself
+	l.ch = make(chan logEntry, 100)
+	go func() {
+		for le := range l.ch {
+			sink.emitLog(le)
+		}
+	}()
+	return l
+}
+
+// Request returns a Logger relating the entries to the request.
+func (l *Logger) Request(reqID string) *Logger {
+	ll := *l
+	ll.reqID = reqID
+	return &ll
+}
+
+// Local returns a Logger writing to the standard logger only.
+func (l *Logger) Local() *Logger {
+	ll := *l
+	ll.ch = nil
+	return &ll
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.output(cdplog.LevelVerbose, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.output(cdplog.LevelInfo, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.output(cdplog.LevelWarning, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.output(cdplog.LevelError, format, args...)
+}
+
+// logLevels ranks the levels by their names
+var logLevels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+var levelNames = map[cdplog.Level]string{
+	cdplog.LevelVerbose: "debug",
+	cdplog.LevelInfo:    "info",
+	cdplog.LevelWarning: "warn",
+	cdplog.LevelError:   "error",
+}
+
+// SetLevel drops the entries below the level: debug, info, warn or error
+func (l *Logger) SetLevel(level string) error {
+	min, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	l.min = min
+	return nil
+}
+
+func (l *Logger) output(level cdplog.Level, format string, args ...interface{}) {
+	if logLevels[levelNames[level]] < l.min {
+		return
+	}
+	var text = fmt.Sprintf(format, args...)
+
+	if l.reqID != "" {
+		l.std.Output(3, fmt.Sprintf("%s reqID=%q", text, l.reqID))
+	} else {
+		l.std.Output(3, text)
+	}
+
+	if l.ch == nil {
+		return
+	}
+	select {
+	case l.ch <- logEntry{reqID: l.reqID, level: level, text: text, t: time.Now()}:
+	default:
+		// drop rather than block the caller
+		logDrops.Inc()
+	}
+}
+
+// consoleTypes are the console API calls of the levels
+var consoleTypes = map[cdplog.Level]runtime.APIType{
+	cdplog.LevelVerbose: runtime.APITypeDebug,
+	cdplog.LevelInfo:    runtime.APITypeInfo,
+	cdplog.LevelWarning: runtime.APITypeWarning,
+	cdplog.LevelError:   runtime.APITypeError,
+}
+
+// logEvent is the Console's event of the entry timestamped on the clock: `Log.entryAdded` linked to the request if any,
+// `Runtime.consoleAPICalled` otherwise
+func logEvent(le logEntry, c clock) event {
+	var ts = c.consoleTime(le.t)
+	if le.reqID != "" {
+		return event{Method: "Log.entryAdded", Params: cdplog.EventEntryAdded{Entry: &cdplog.Entry{
+			Source:           cdplog.SourceNetwork,
+			Level:            le.level,
+			Text:             le.text,
+			Timestamp:        ts,
+			NetworkRequestID: network.RequestID(le.reqID),
+		}}}
+	}
+	text, _ := json.Marshal(le.text)
+	return event{Method: "Runtime.consoleAPICalled", Params: runtime.EventConsoleAPICalled{
+		Type:               consoleTypes[le.level],
+		Args:               []*runtime.RemoteObject{{Type: runtime.TypeString, Value: text}},
+		ExecutionContextID: executionContextID,
+		Timestamp:          ts,
+	}}
+}
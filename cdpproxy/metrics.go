@@ -0,0 +1,31 @@
+package cdpproxy
+
+import (
+	httpx "github.com/gmarik/cdp-proxy/http"
+	"github.com/gmarik/cdp-proxy/metrics"
+)
+
+// Metrics are the proxy's and the sessions' metrics, and the traced requests' ones of package http,
+// exposed once registered, e.g. `metrics.Default.Register(cdpproxy.Metrics)`
+var Metrics = new(metrics.Registry)
+
+func init() {
+	Metrics.Register(httpx.Metrics)
+}
+
+var (
+	tunnelsActive = Metrics.NewGauge("cdp_proxy_tunnels_active",
+		"CONNECT tunnels open.")
+	tunnelBytes = Metrics.NewCounterVec("cdp_proxy_tunnel_bytes_total",
+		"CONNECT tunnels bytes from(in) and to(out) the clients.", "direction")
+	cdpClients = Metrics.NewGaugeVec("cdp_proxy_cdp_clients",
+		"Connected CDP clients by session.", "session")
+	eventBusDrops = Metrics.NewCounterVec("cdp_proxy_eventbus_dropped_readers_total",
+		"Event bus readers dropped for falling behind, by session.", "session")
+	eventBusQueueDrops = Metrics.NewCounterVec("cdp_proxy_eventbus_queue_dropped_events_total",
+		"Events not queued for the queued readers, e.g. the traffic log, for the queue being full, by session.", "session")
+	logDrops = Metrics.NewCounter("cdp_proxy_log_dropped_total",
+		"Log entries not forwarded to DevTools for the forwarding falling behind.")
+	bodyStoreBytes = Metrics.NewGauge("cdp_proxy_body_store_bytes",
+		"Response bodies bytes kept for Network.getResponseBody.")
+)
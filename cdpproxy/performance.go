@@ -0,0 +1,50 @@
+package cdpproxy
+
+import (
+	goruntime "runtime"
+	"time"
+
+	"github.com/chromedp/cdproto/performance"
+)
+
+// performanceMetrics are the Go runtime and the traffic stats of the process for Performance.getMetrics.
+// DevTools' Performance Monitor graphs only the metrics it knows, so the stats meaning the same are reported as those too:
+// the process CPU time as the tasks' duration, for the CPU usage, and the Go heap as the JS heap.
+// The rest have their own names, listed by getMetrics only.
+func performanceMetrics(c clock) []*performance.Metric {
+	var ms goruntime.MemStats
+	goruntime.ReadMemStats(&ms)
+
+	var (
+		goroutines   = float64(goruntime.NumGoroutine())
+		requests     = metricSum("cdp_proxy_requests_total")
+		inFlight     = metricSum("cdp_proxy_requests_in_flight")
+		tunnels      = metricSum("cdp_proxy_tunnels_active")
+		clients      = metricSum("cdp_proxy_cdp_clients")
+		gcPauseTotal = time.Duration(ms.PauseTotalNs).Seconds()
+	)
+	return []*performance.Metric{
+		{Name: "Timestamp", Value: c.since(time.Now()).Seconds()},
+		{Name: "TaskDuration", Value: processCPU().Seconds()},
+		{Name: "JSHeapUsedSize", Value: float64(ms.HeapAlloc)},
+		{Name: "JSHeapTotalSize", Value: float64(ms.HeapSys)},
+
+		{Name: "Goroutines", Value: goroutines},
+		{Name: "HeapAlloc", Value: float64(ms.HeapAlloc)},
+		{Name: "HeapSys", Value: float64(ms.HeapSys)},
+		{Name: "HeapObjects", Value: float64(ms.HeapObjects)},
+		{Name: "NumGC", Value: float64(ms.NumGC)},
+		{Name: "GCPauseTotalDuration", Value: gcPauseTotal},
+		{Name: "GCLastPauseDuration", Value: time.Duration(ms.PauseNs[(ms.NumGC+255)%256]).Seconds()},
+		{Name: "Requests", Value: requests},
+		{Name: "RequestsInFlight", Value: inFlight},
+		{Name: "Tunnels", Value: tunnels},
+		{Name: "CDPClients", Value: clients},
+	}
+}
+
+// metricSum is the metric's value, registered or not
+func metricSum(name string) float64 {
+	v, _ := Metrics.Sum(name)
+	return v
+}
@@ -0,0 +1,311 @@
+package cdpproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	goruntime "runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/heapprofiler"
+	"github.com/chromedp/cdproto/profiler"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/google/pprof/profile"
+)
+
+// cpuProfiles runs the Go CPU profile of the process for the Profiler domain,
+// one at a time as the profile is process wide
+var cpuProfiles = new(cpuProfiler)
+
+type cpuProfiler struct {
+	mu    sync.Mutex
+	owner *wsConn
+	buf   bytes.Buffer
+	start time.Time
+}
+
+// Start starts profiling for the connection
+func (p *cpuProfiler) Start(owner *wsConn) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.owner != nil {
+		return errors.New("Profiler is already started by another client")
+	}
+	p.buf.Reset()
+	if err := pprof.StartCPUProfile(&p.buf); err != nil {
+		return fmt.Errorf("pprof.StartCPUProfile: %w", err)
+	}
+	p.owner, p.start = owner, time.Now()
+	return nil
+}
+
+// Stop stops the connection's profiling, returning the profile recorded timed on the session's clock
+func (p *cpuProfiler) Stop(owner *wsConn, c clock) (*profiler.Profile, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.owner != owner {
+		return nil, errors.New("Profiler is not started")
+	}
+	pprof.StopCPUProfile()
+	p.owner = nil
+
+	pp, err := profile.Parse(&p.buf)
+	if err != nil {
+		return nil, fmt.Errorf("profile.Parse: %w", err)
+	}
+	return cpuProfile(pp, c, p.start, time.Now())
+}
+
+// release stops the profiling of the connection gone
+func (p *cpuProfiler) release(owner *wsConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.owner == owner {
+		pprof.StopCPUProfile()
+		p.owner = nil
+	}
+}
+
+// frameKey identifies the function nodes of the profiles, as V8 does
+type frameKey struct {
+	function, file string
+	startLine      int64
+}
+
+// nodeKey identifies the node among its parent's children
+type nodeKey struct {
+	parent int64
+	frameKey
+}
+
+type frame struct {
+	frameKey
+	line int64
+}
+
+// frames are the sample's stack from the root down to the leaf, the inlined functions included
+func frames(s *profile.Sample) []frame {
+	var ff []frame
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		var (
+			loc   = s.Location[i]
+			lines = loc.Line
+		)
+		if len(lines) == 0 {
+			// not symbolized
+			lines = []profile.Line{{}}
+		}
+		// NOTE: the last line is the caller of the inlined ones
+		for j := len(lines) - 1; j >= 0; j-- {
+			var k = frameKey{function: fmt.Sprintf("0x%x", loc.Address)}
+			if fn := lines[j].Function; fn != nil {
+				k = frameKey{function: fn.Name, file: fn.Filename, startLine: fn.StartLine}
+			}
+			ff = append(ff, frame{frameKey: k, line: lines[j].Line})
+		}
+	}
+	return ff
+}
+
+func callFrame(k frameKey) *runtime.CallFrame {
+	var cf = &runtime.CallFrame{FunctionName: k.function}
+	if k.file != "" {
+		cf.URL = "file://" + k.file
+	}
+	if k.startLine > 0 {
+		// 0-based
+		cf.LineNumber = k.startLine - 1
+	}
+	return cf
+}
+
+func sampleIndex(p *profile.Profile, typ string) (int, error) {
+	for i, st := range p.SampleType {
+		if st.Type == typ {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("profile: no %q samples", typ)
+}
+
+// micros is the profile time of t, the microseconds on the clock
+func micros(c clock, t time.Time) float64 {
+	return float64(c.since(t).Nanoseconds()) / 1e3
+}
+
+// cpuProfile converts the Go CPU profile into the V8 one.
+// The Go profile aggregates the samples, so they're laid out one stack after another, scaled down to the wall time
+// if the CPU time exceeds it, the rest of the wall time being idle.
+func cpuProfile(p *profile.Profile, c clock, start, end time.Time) (*profiler.Profile, error) {
+	countIdx, err := sampleIndex(p, "samples")
+	if err != nil {
+		return nil, err
+	}
+	cpuIdx, err := sampleIndex(p, "cpu")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		root  = &profiler.ProfileNode{ID: 1, CallFrame: &runtime.CallFrame{FunctionName: "(root)"}}
+		nodes = []*profiler.ProfileNode{root}
+		ids   = make(map[nodeKey]*profiler.ProfileNode)
+		child = func(parent *profiler.ProfileNode, k frameKey) *profiler.ProfileNode {
+			key := nodeKey{parent.ID, k}
+			if n, ok := ids[key]; ok {
+				return n
+			}
+			n := &profiler.ProfileNode{ID: int64(len(nodes) + 1), CallFrame: callFrame(k)}
+			nodes = append(nodes, n)
+			parent.Children = append(parent.Children, n.ID)
+			ids[key] = n
+			return n
+		}
+		ticks = make(map[*profiler.ProfileNode]map[int64]int64)
+	)
+
+	type run struct {
+		node  *profiler.ProfileNode
+		count int64
+		cpu   int64
+	}
+	var (
+		runs     []run
+		totalCPU int64
+	)
+	for _, s := range p.Sample {
+		count, cpu := s.Value[countIdx], s.Value[cpuIdx]
+		if count <= 0 {
+			continue
+		}
+		var (
+			n  = root
+			ff = frames(s)
+		)
+		for _, f := range ff {
+			n = child(n, f.frameKey)
+		}
+		n.HitCount += count
+		if len(ff) > 0 && ff[len(ff)-1].line > 0 {
+			if ticks[n] == nil {
+				ticks[n] = make(map[int64]int64)
+			}
+			ticks[n][ff[len(ff)-1].line] += count
+		}
+		runs = append(runs, run{node: n, count: count, cpu: cpu})
+		totalCPU += cpu
+	}
+	for n, lines := range ticks {
+		for line, count := range lines {
+			n.PositionTicks = append(n.PositionTicks, &profiler.PositionTickInfo{Line: line, Ticks: count})
+		}
+	}
+
+	var (
+		wall  = end.Sub(start).Nanoseconds()
+		scale = 1.0
+		out   = &profiler.Profile{Nodes: nodes, StartTime: micros(c, start), EndTime: micros(c, end)}
+		// the sample's delta is the previous one's duration
+		elapsed, prev int64
+	)
+	if totalCPU > wall && totalCPU > 0 {
+		scale = float64(wall) / float64(totalCPU)
+	}
+	for _, r := range runs {
+		d := int64(float64(r.cpu)*scale) / r.count
+		for i := int64(0); i < r.count; i++ {
+			out.Samples = append(out.Samples, r.node.ID)
+			out.TimeDeltas = append(out.TimeDeltas, prev/1e3)
+			elapsed += prev
+			prev = d
+		}
+	}
+	if idle := wall - elapsed - prev; idle > 0 {
+		n := child(root, frameKey{function: "(idle)"})
+		n.HitCount++
+		out.Nodes = nodes
+		out.Samples = append(out.Samples, n.ID)
+		out.TimeDeltas = append(out.TimeDeltas, prev/1e3)
+	}
+	return out, nil
+}
+
+// heapProfile is the Go heap profile, after a GC so that it's up to date
+func heapProfile() (*profile.Profile, error) {
+	goruntime.GC()
+	var buf bytes.Buffer
+	if err := pprof.Lookup("heap").WriteTo(&buf, 0); err != nil {
+		return nil, fmt.Errorf("pprof.WriteTo: %w", err)
+	}
+	return profile.Parse(&buf)
+}
+
+// heapSamplingProfile converts the space allocated since the base Go heap profile into the V8 sampling heap profile.
+// The Go runtime samples the allocations every runtime.MemProfileRate bytes.
+func heapSamplingProfile(base *profile.Profile) (*heapprofiler.SamplingHeapProfile, error) {
+	p, err := heapProfile()
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		// the allocations are cumulative, so the difference is what's allocated since the base
+		base = base.Copy()
+		base.Scale(-1)
+		if p, err = profile.Merge([]*profile.Profile{p, base}); err != nil {
+			return nil, fmt.Errorf("profile.Merge: %w", err)
+		}
+	}
+	idx, err := sampleIndex(p, "alloc_space")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lastID   int64 = 1
+		root           = &heapprofiler.SamplingHeapProfileNode{ID: lastID, CallFrame: &runtime.CallFrame{FunctionName: "(root)"}}
+		out            = &heapprofiler.SamplingHeapProfile{Head: root, Samples: []*heapprofiler.SamplingHeapProfileSample{}}
+		children       = make(map[*heapprofiler.SamplingHeapProfileNode]map[frameKey]*heapprofiler.SamplingHeapProfileNode)
+	)
+	for _, s := range p.Sample {
+		size := s.Value[idx]
+		if size <= 0 {
+			continue
+		}
+		var n = root
+		for _, f := range frames(s) {
+			if children[n] == nil {
+				children[n] = make(map[frameKey]*heapprofiler.SamplingHeapProfileNode)
+			}
+			c, ok := children[n][f.frameKey]
+			if !ok {
+				lastID++
+				c = &heapprofiler.SamplingHeapProfileNode{ID: lastID, CallFrame: callFrame(f.frameKey)}
+				n.Children = append(n.Children, c)
+				children[n][f.frameKey] = c
+			}
+			n = c
+		}
+		n.SelfSize += float64(size)
+		out.Samples = append(out.Samples, &heapprofiler.SamplingHeapProfileSample{
+			Size:    float64(size),
+			NodeID:  n.ID,
+			Ordinal: float64(len(out.Samples) + 1),
+		})
+	}
+	fixChildren(root)
+	return out, nil
+}
+
+// fixChildren makes the leaves' children empty lists, as DevTools expects
+func fixChildren(n *heapprofiler.SamplingHeapProfileNode) {
+	if n.Children == nil {
+		n.Children = []*heapprofiler.SamplingHeapProfileNode{}
+	}
+	for _, c := range n.Children {
+		fixChildren(c)
+	}
+}
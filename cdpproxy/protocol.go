@@ -0,0 +1,99 @@
+package cdpproxy
+
+// protocol describes the subset of CDP the server implements, served at `/json/protocol`
+// https://github.com/ChromeDevTools/devtools-protocol/tree/master/json
+var protocol = protocolDescription{
+	Version: protocolVersionInfo{Major: "1", Minor: "3"},
+	Domains: []protocolDomain{
+		{
+			Domain:      "Browser",
+			Description: "The Browser domain describes the proxy itself.",
+			Commands:    names("getVersion"),
+		},
+		{
+			Domain:      "Emulation",
+			Description: "Emulation isn't supported, the proxy has no page to emulate.",
+			Commands:    names("canEmulate"),
+		},
+		{
+			Domain:      "HeapProfiler",
+			Description: "HeapProfiler domain samples the space the Go process allocates, backed by the Go heap profile.",
+			Commands:    names("enable", "disable", "startSampling", "getSamplingProfile", "stopSampling", "collectGarbage"),
+		},
+		{
+			Domain:      "Log",
+			Description: "Log entries of the proxy and the CDP server related to the requests, the rest are console API calls.",
+			Commands:    names("enable", "disable"),
+			Events:      names("entryAdded"),
+		},
+		{
+			Domain:      "Network",
+			Description: "Network domain reports the proxied requests.",
+			Commands: names(
+				"enable", "disable", "canEmulateNetworkConditions", "getResponseBody",
+				"getCookies", "getAllCookies", "deleteCookies", "setCookie",
+			),
+			Events: names(
+				"requestWillBeSent", "requestWillBeSentExtraInfo",
+				"responseReceived", "responseReceivedExtraInfo",
+				"dataReceived", "loadingFinished", "loadingFailed",
+			),
+		},
+		{
+			Domain:      "Page",
+			Description: "Page domain provides the stub page the proxied requests belong to.",
+			Commands:    names("enable", "disable", "canScreencast", "getResourceTree"),
+		},
+		{
+			Domain:      "Performance",
+			Description: "Performance domain reports the Go runtime and the traffic stats, graphed by the Performance Monitor.",
+			Commands:    names("enable", "disable", "getMetrics"),
+		},
+		{
+			Domain:      "Profiler",
+			Description: "Profiler domain runs the Go CPU profile of the process, one at a time.",
+			Commands:    names("enable", "disable", "setSamplingInterval", "start", "stop"),
+		},
+		{
+			Domain:      "Runtime",
+			Description: "Runtime domain evaluates the console commands, see `help()`.",
+			Commands:    names("enable", "disable", "evaluate", "getProperties", "releaseObjectGroup"),
+			Events:      names("executionContextCreated", "consoleAPICalled"),
+		},
+		{
+			Domain:      "Target",
+			Description: "Target domain lists the sessions.",
+			Commands:    names("getTargets", "getTargetInfo", "setDiscoverTargets"),
+			Events:      names("targetCreated"),
+		},
+	},
+}
+
+type protocolDescription struct {
+	Version protocolVersionInfo `json:"version"`
+	Domains []protocolDomain    `json:"domains"`
+}
+
+type protocolVersionInfo struct {
+	Major string `json:"major"`
+	Minor string `json:"minor"`
+}
+
+type protocolDomain struct {
+	Domain      string         `json:"domain"`
+	Description string         `json:"description,omitempty"`
+	Commands    []protocolItem `json:"commands"`
+	Events      []protocolItem `json:"events,omitempty"`
+}
+
+type protocolItem struct {
+	Name string `json:"name"`
+}
+
+func names(nn ...string) []protocolItem {
+	var items = make([]protocolItem, len(nn))
+	for i := range nn {
+		items[i] = protocolItem{Name: nn[i]}
+	}
+	return items
+}
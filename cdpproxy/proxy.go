@@ -0,0 +1,290 @@
+package cdpproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+	"github.com/gmarik/cdp-proxy/otlp"
+)
+
+// Proxy is a forward HTTP proxy, tunneling the CONNECT requests, recording the requests into the Sessions
+type Proxy struct {
+	// Sessions the requests are recorded into, the DefaultSession only if nil
+	Sessions *Sessions
+	// Logger defaults to the one forwarding to the Sessions
+	Logger *Logger
+	// Middleware wraps the forwarding within the recording, e.g. to block or throttle the requests
+	Middleware func(http.Handler) http.Handler
+	// Auth wraps the recording, e.g. to reject the clients without recording their requests
+	Auth func(http.Handler) http.Handler
+	// Transport forwards the requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// DialTimeout of the CONNECT tunnels' upstream connections. Default: 5s
+	DialTimeout time.Duration
+	// Exporter records the upstream round trips as OTLP spans, if set
+	Exporter *otlp.Exporter
+	// InjectTraceparent propagates the spans upstream with the `traceparent` header
+	InjectTraceparent bool
+	// ShutdownTimeout bounds draining the requests and the tunnels once the ListenAndServe's ctx is done. Default: 5s
+	ShutdownTimeout time.Duration
+
+	once    sync.Once
+	handler http.Handler
+	tunnels tunnelSet
+}
+
+func (p *Proxy) init() {
+	if p.Sessions == nil {
+		p.Sessions = NewSessions(nil)
+	}
+	if p.Logger == nil {
+		p.Logger = NewLogger(p.Sessions)
+	}
+	if p.DialTimeout == 0 {
+		p.DialTimeout = 5 * time.Second
+	}
+	p.tunnels.conns = make(map[[2]net.Conn]struct{})
+
+	var next http.Handler = http.HandlerFunc(p.forward)
+	if p.Middleware != nil {
+		next = p.Middleware(next)
+	}
+	p.handler = p.Sessions.CookieHandler(httpx.Handler(p.Sessions, next))
+	if p.Auth != nil {
+		p.handler = p.Auth(p.handler)
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.once.Do(p.init)
+	p.handler.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves till the ctx is done, then shuts down gracefully, see Serve
+func (p *Proxy) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("net.Listen: %w", err)
+	}
+	return p.Serve(ctx, ln)
+}
+
+// Serve serves the listener, e.g. a TLS one for an HTTPS proxy, till the ctx is done,
+// then drains the in-flight requests and the tunnels within the ShutdownTimeout, closing the remaining ones.
+// The Proxy serves any number of listeners.
+func (p *Proxy) Serve(ctx context.Context, ln net.Listener) error {
+	var srv = &http.Server{Handler: p, ConnContext: httpx.ConnContext}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("http.Serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	timeout := p.ShutdownTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	sctx, cancel_Fn := context.WithTimeout(context.Background(), timeout)
+	defer cancel_Fn()
+
+	err := srv.Shutdown(sctx)
+	if err != nil {
+		srv.Close()
+	}
+	if terr := p.Shutdown(sctx); err == nil {
+		err = terr
+	}
+	if err != nil {
+		return fmt.Errorf("http.Server.Shutdown: %w", err)
+	}
+	return nil
+}
+
+// Shutdown waits for the CONNECT tunnels to finish, closing the remaining ones once the ctx is done.
+// The tunnels are hijacked, so http.Server.Shutdown doesn't wait for them.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.once.Do(p.init)
+	return p.tunnels.Drain(ctx)
+}
+
+// forward forwards the request, or tunnels the CONNECT one
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	u := *r.URL
+	u.Host = r.Host
+	u.Scheme = "http"
+
+	if _, port, _ := net.SplitHostPort(r.Host); port == "443" {
+		u.Scheme = "https"
+	}
+
+	var l = p.Logger.Request(httpx.RequestID(r.Context()))
+	l.Infof("[proxy] %s", u.String())
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(&u, l).ServeHTTP(w, r)
+	} else {
+		p.forwardProxy(&u, l).ServeHTTP(w, r)
+	}
+}
+
+func (p *Proxy) tunnel(u *url.URL, l *Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpErr := func(code int, err error) {
+			l.Errorf("[CONNECT]: error=%v", err)
+			http.Error(w, http.StatusText(code), code)
+		}
+		l.Infof("[CONNECT]: start:%s", u.Host)
+		defer l.Infof("[CONNECT]: done: %s", u.Host)
+
+		parent, _ := otlp.ParseTraceparent(r.Header.Get("traceparent"))
+		span := p.Exporter.Start("CONNECT "+r.Host, otlp.SpanKindClient, parent, time.Now())
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("net.peer.name", u.Hostname())
+		if reqID := httpx.RequestID(r.Context()); reqID != "" {
+			span.SetAttr("cdp_proxy.request_id", reqID)
+		}
+		defer func() { span.End(time.Now()) }()
+
+		span.AddEvent("connect.start", time.Now(), "net.peer.address", r.Host)
+		dconn, err := net.DialTimeout("tcp", r.Host, p.DialTimeout)
+		span.AddEvent("connect.done", time.Now(), "net.peer.address", r.Host, "error", errString(err))
+		if err != nil {
+			span.SetError(err.Error())
+			httpErr(http.StatusServiceUnavailable, err)
+			return
+		}
+
+		tunnelsActive.Inc()
+		defer tunnelsActive.Dec()
+
+		w.WriteHeader(http.StatusOK)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		} else {
+			l.Warnf("http.Flusher: unavailable")
+		}
+
+		var sconn net.Conn
+		if h, ok := w.(http.Hijacker); !ok {
+			httpErr(http.StatusInternalServerError, fmt.Errorf("http.Hijacker: unavailable"))
+			return
+		} else {
+			conn, _, err := h.Hijack()
+			if err != nil {
+				httpErr(http.StatusServiceUnavailable, err)
+				return
+			}
+			sconn = conn
+		}
+
+		type readWriteCloser interface {
+			net.Conn
+			CloseRead() error
+			CloseWrite() error
+		}
+
+		var src, dst = sconn.(readWriteCloser), dconn.(readWriteCloser)
+		defer p.tunnels.add(src, dst)()
+		// TODO:
+		var done = make(chan struct{})
+		go func() {
+			n, err := io.Copy(src, dst)
+			l.Debugf("src<-dst: n=%d error=%v", n, err)
+			tunnelBytes.WithLabelValues("out").Add(float64(n))
+			dst.CloseRead()
+			src.CloseWrite()
+			done <- struct{}{}
+		}()
+		go func() {
+			n, err := io.Copy(dst, src)
+			l.Debugf("src->dst: n=%d error=%v", n, err)
+			tunnelBytes.WithLabelValues("in").Add(float64(n))
+			dst.CloseWrite()
+			src.CloseRead()
+			done <- struct{}{}
+		}()
+		<-done
+		<-done
+	})
+}
+
+type tunnelSet struct {
+	mu    sync.Mutex
+	conns map[[2]net.Conn]struct{}
+}
+
+func (ts *tunnelSet) add(src, dst net.Conn) (remove func()) {
+	ts.mu.Lock()
+	ts.conns[[2]net.Conn{src, dst}] = struct{}{}
+	ts.mu.Unlock()
+
+	return func() {
+		ts.mu.Lock()
+		delete(ts.conns, [2]net.Conn{src, dst})
+		ts.mu.Unlock()
+	}
+}
+
+// Drain waits for the tunnels to finish, closing the remaining ones once the ctx is done
+func (ts *tunnelSet) Drain(ctx context.Context) error {
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+
+	for {
+		ts.mu.Lock()
+		n := len(ts.conns)
+		ts.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			ts.mu.Lock()
+			for c := range ts.conns {
+				c[0].Close()
+				c[1].Close()
+			}
+			ts.mu.Unlock()
+			return fmt.Errorf("tunnels=%d: %w", n, ctx.Err())
+		}
+	}
+}
+
+func (p *Proxy) forwardProxy(target *url.URL, l *Logger) *httputil.ReverseProxy {
+	var transport = p.Transport
+	if p.Exporter != nil {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		transport = &tracingTransport{next: transport, exp: p.Exporter, inject: p.InjectTraceparent}
+	}
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			l.Errorf("[proxy] %s: error=%v", target.String(), err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		Director: func(req *http.Request) {
+			// TODO:
+			req.Host = target.Host
+			if _, ok := req.Header["User-Agent"]; !ok {
+				req.Header.Set("User-Agent", "")
+			}
+		},
+	}
+}
@@ -0,0 +1,91 @@
+package cdpproxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+)
+
+// maxHeldRedirects bounds the redirects awaiting the requests following them, the oldest ones finish first
+const maxHeldRedirects = 100
+
+// redirects are the finished redirects of the event bus awaiting the requests following them.
+// Chrome reports the chain as the same request: the follow-up's requestWillBeSent has the `redirectResponse`
+// and there's no loadingFinished for the redirect, DevTools links the hops only while the request is in flight.
+// So the redirect's loadingFinished is held back, dropped once followed, or sent once another request
+// the follow-up would've been sent as is, see redirectKey, or the parent request finishes.
+type redirects struct {
+	mu   sync.Mutex
+	held []*heldRedirect
+}
+
+type heldRedirect struct {
+	reqID string
+	// req is the redirected request, the follow-up's Response.Request
+	req    *http.Request
+	key    string
+	finish func()
+}
+
+// redirectKey is the key of the requests sent the way the redirect's follow-up is:
+// within the same parent, over the same connection if served, or the client requests sent on their own.
+// The loader is the parent's, the request's own if it has no parent.
+func redirectKey(reqID, loaderID string, req *http.Request) string {
+	if loaderID != reqID {
+		return "loader:" + loaderID
+	}
+	if id := httpx.ServedConnID(req.Context()); id != 0 {
+		return fmt.Sprintf("conn:%d", id)
+	}
+	return "client"
+}
+
+// finished holds the finish of the redirect back till it's followed or released
+func (rs *redirects) finished(reqID string, req *http.Request, key string, finish func()) {
+	rs.mu.Lock()
+	rs.held = append(rs.held, &heldRedirect{reqID: reqID, req: req, key: key, finish: finish})
+	var oldest *heldRedirect
+	if len(rs.held) > maxHeldRedirects {
+		oldest, rs.held = rs.held[0], rs.held[1:]
+	}
+	rs.mu.Unlock()
+
+	if oldest != nil {
+		oldest.finish()
+	}
+}
+
+// follow reports whether the redirected request reqID is held, taking it over for the follow-up
+func (rs *redirects) follow(reqID string, req *http.Request) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for i, hr := range rs.held {
+		if hr.reqID == reqID && hr.req == req {
+			rs.held = append(rs.held[:i], rs.held[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release finishes the redirects held under the key, not followed
+func (rs *redirects) release(key string) {
+	rs.mu.Lock()
+	var released, kept []*heldRedirect
+	for _, hr := range rs.held {
+		if hr.key == key {
+			released = append(released, hr)
+		} else {
+			kept = append(kept, hr)
+		}
+	}
+	rs.held = kept
+	rs.mu.Unlock()
+
+	for _, hr := range released {
+		hr.finish()
+	}
+}
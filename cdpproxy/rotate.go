@@ -0,0 +1,155 @@
+package cdpproxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatingFile appends to the file at path, renaming it aside once it exceeds maxSize bytes
+// or gets older than maxAge, and gzips the rotated files in background.
+// The rotation happens on write, a zero limit disables it.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	gzips sync.WaitGroup
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size, rf.opened = f, fi.Size(), time.Now()
+	if fi.Size() > 0 {
+		// appending, the file's age counts from its last write rather than the process start
+		rf.opened = fi.ModTime()
+	}
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	var (
+		tooBig = rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize
+		tooOld = rf.maxAge > 0 && time.Since(rf.opened) >= rf.maxAge
+	)
+	if rf.size > 0 && (tooBig || tooOld) {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate: %w", err)
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+
+	var (
+		ext     = filepath.Ext(rf.path)
+		base    = strings.TrimSuffix(rf.path, ext) + "-" + time.Now().Format("20060102T150405")
+		rotated = base + ext
+	)
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	if err := os.Rename(rf.path, rotated); err != nil {
+		return err
+	}
+
+	rf.gzips.Add(1)
+	go func() {
+		defer rf.gzips.Done()
+		if err := gzipFile(rotated); err != nil {
+			log.Printf("rotatingFile: gzip: path=%q error=%q", rotated, err)
+		}
+	}()
+
+	return rf.open()
+}
+
+// Close closes the file waiting for the rotated ones to be gzipped
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	var err error
+	if rf.f != nil {
+		err = rf.f.Close()
+		rf.f = nil
+	}
+	rf.mu.Unlock()
+
+	rf.gzips.Wait()
+	return err
+}
+
+// gzipFile replaces the file with its gzipped copy
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,260 @@
+package cdpproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+)
+
+// DefaultSession is the name of the session used when requests aren't keyed.
+const DefaultSession = "cdp-proxy"
+
+// Sessions routes the traced requests into isolated, named event buses
+// so that each session shows up as a separate DevTools target.
+// It implements the Tracer of package http.
+type Sessions struct {
+	// Key names the session the request belongs to, DefaultSession if nil or empty
+	Key func(*http.Request) string
+	// ReaderTimeout is how long a slow CDP client is waited for before it's dropped, DefaultReaderTimeout if 0
+	ReaderTimeout time.Duration
+	// MaxBodySize and MaxBodiesSize cap the response bodies kept by the sessions,
+	// DefaultMaxBodySize and DefaultMaxBodiesSize if 0
+	MaxBodySize, MaxBodiesSize int
+
+	mu sync.RWMutex
+	m  map[string]*EventBus
+
+	// reqID -> *EventBus of the in-flight requests
+	reqs sync.Map
+
+	// subscribers are called with each session added
+	subscribers []func(name string, eb *EventBus)
+}
+
+// NewSessions keys the requests into the sessions by the key, all into the DefaultSession if nil
+func NewSessions(key func(*http.Request) string) *Sessions {
+	return &Sessions{
+		Key: key,
+		m:   make(map[string]*EventBus),
+	}
+}
+
+// Add registers the event bus as the named session.
+func (ss *Sessions) Add(name string, eb *EventBus) {
+	ss.mu.Lock()
+	ss.setup(name, eb)
+	ss.m[name] = eb
+	subscribers := ss.subscribers
+	ss.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(name, eb)
+	}
+}
+
+// setup configures the event bus as the named session
+func (ss *Sessions) setup(name string, eb *EventBus) {
+	eb.name = name
+	eb.readerTimeout = ss.ReaderTimeout
+	eb.store.SetLimits(ss.MaxBodySize, ss.MaxBodiesSize)
+}
+
+// Subscribe calls the fn with each of the current sessions and the ones added later.
+func (ss *Sessions) Subscribe(fn func(name string, eb *EventBus)) {
+	ss.mu.Lock()
+	ss.subscribers = append(ss.subscribers, fn)
+	var ebs = make(map[string]*EventBus, len(ss.m))
+	for name, eb := range ss.m {
+		ebs[name] = eb
+	}
+	ss.mu.Unlock()
+
+	for name, eb := range ebs {
+		fn(name, eb)
+	}
+}
+
+// Remove unregisters the named session, reporting whether it existed.
+func (ss *Sessions) Remove(name string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	_, ok := ss.m[name]
+	delete(ss.m, name)
+	return ok
+}
+
+// Get returns the named session's event bus, creating it if needed.
+func (ss *Sessions) Get(name string) *EventBus {
+	if name == "" {
+		name = DefaultSession
+	}
+
+	ss.mu.RLock()
+	eb, ok := ss.m[name]
+	ss.mu.RUnlock()
+	if ok {
+		return eb
+	}
+
+	ss.mu.Lock()
+	if eb, ok := ss.m[name]; ok {
+		ss.mu.Unlock()
+		return eb
+	}
+	eb = NewEventBus()
+	ss.setup(name, eb)
+	ss.m[name] = eb
+	subscribers := ss.subscribers
+	ss.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(name, eb)
+	}
+	return eb
+}
+
+// Lookup returns the named session's event bus, if any.
+func (ss *Sessions) Lookup(name string) (*EventBus, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	eb, ok := ss.m[name]
+	return eb, ok
+}
+
+// Names returns the sorted session names.
+func (ss *Sessions) Names() []string {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	var names = make([]string, 0, len(ss.m))
+	for name := range ss.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (ss *Sessions) session(req *http.Request) *EventBus {
+	// the child requests are recorded in the session of their parent
+	if eb, ok := ss.lookupReq(httpx.RequestID(req.Context())); ok {
+		return eb
+	}
+	if ss.Key == nil {
+		return ss.Get(DefaultSession)
+	}
+	return ss.Get(ss.Key(req))
+}
+
+func (ss *Sessions) lookupReq(reqID string) (*EventBus, bool) {
+	v, ok := ss.reqs.Load(reqID)
+	if !ok {
+		return nil, false
+	}
+	return v.(*EventBus), true
+}
+
+func (ss *Sessions) RequestWillBeSent(req *http.Request) (reqID string) {
+	eb := ss.session(req)
+	reqID = eb.RequestWillBeSent(req)
+	ss.reqs.Store(reqID, eb)
+	return reqID
+}
+
+func (ss *Sessions) ResponseReceived(reqID string, re *http.Response) {
+	if eb, ok := ss.lookupReq(reqID); ok {
+		eb.ResponseReceived(reqID, re)
+	}
+}
+
+func (ss *Sessions) DataReceived(reqID string, data []byte) {
+	if eb, ok := ss.lookupReq(reqID); ok {
+		eb.DataReceived(reqID, data)
+	}
+}
+
+func (ss *Sessions) LoadingFinished(reqID string, re *http.Response) {
+	if eb, ok := ss.lookupReq(reqID); ok {
+		eb.LoadingFinished(reqID, re)
+		ss.reqs.Delete(reqID)
+	}
+}
+
+func (ss *Sessions) LoadingFailed(reqID string, req *http.Request, err error) {
+	if eb, ok := ss.lookupReq(reqID); ok {
+		eb.LoadingFailed(reqID, req, err)
+		ss.reqs.Delete(reqID)
+	}
+}
+
+// CookieHandler applies the cookies set from DevTools in the request's session.
+func (ss *Sessions) CookieHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ss.session(r).cookies.Apply(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// emitLog sends the request's log entries to its session, and the rest to all the sessions
+func (ss *Sessions) emitLog(le logEntry) {
+	if eb, ok := ss.lookupReq(le.reqID); ok {
+		eb.emitLog(le)
+		return
+	}
+
+	ss.mu.RLock()
+	var ebs = make([]*EventBus, 0, len(ss.m))
+	for _, eb := range ss.m {
+		ebs = append(ebs, eb)
+	}
+	ss.mu.RUnlock()
+
+	for _, eb := range ebs {
+		eb.emitLog(le)
+	}
+}
+
+// SessionByPort keys the requests by the port of the proxy listener they came in through.
+func SessionByPort(r *http.Request) string {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return ""
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	return port
+}
+
+// SessionByIP keys the requests by the client IP.
+func SessionByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SessionByUser keys the requests by the proxy user, see WithProxyUser,
+// or by the Proxy-Authorization Basic user name.
+func SessionByUser(r *http.Request) string {
+	if user, ok := r.Context().Value(proxyUserKey{}).(string); ok {
+		return user
+	}
+	user, _, ok := (&http.Request{Header: http.Header{
+		"Authorization": r.Header["Proxy-Authorization"],
+	}}).BasicAuth()
+	if !ok {
+		return ""
+	}
+	return user
+}
+
+type proxyUserKey struct{}
+
+// WithProxyUser returns the request of the proxy user for SessionByUser,
+// e.g. once its Proxy-Authorization is checked and removed so that the credentials aren't recorded
+func WithProxyUser(r *http.Request, user string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), proxyUserKey{}, user))
+}
@@ -0,0 +1,118 @@
+package cdpproxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+	"github.com/gmarik/cdp-proxy/otlp"
+)
+
+// tracingTransport records the upstream round trips as OTLP client spans,
+// continuing the incoming `traceparent` and, if inject, propagating the span upstream
+type tracingTransport struct {
+	next   http.RoundTripper
+	exp    *otlp.Exporter
+	inject bool
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	parent, _ := otlp.ParseTraceparent(req.Header.Get("traceparent"))
+	span := t.exp.Start("HTTP "+req.Method, otlp.SpanKindClient, parent, time.Now())
+	span.SetAttr("http.method", req.Method)
+	span.SetAttr("http.url", req.URL.String())
+	span.SetAttr("net.peer.name", req.URL.Hostname())
+	if reqID := httpx.RequestID(req.Context()); reqID != "" {
+		span.SetAttr("cdp_proxy.request_id", reqID)
+	}
+
+	var trace = &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			span.AddEvent("dns.start", time.Now(), "net.host.name", info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			span.AddEvent("dns.done", time.Now(), "error", errString(info.Err))
+		},
+		ConnectStart: func(network, addr string) {
+			span.AddEvent("connect.start", time.Now(), "net.peer.address", addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			span.AddEvent("connect.done", time.Now(), "net.peer.address", addr, "error", errString(err))
+		},
+		TLSHandshakeStart: func() {
+			span.AddEvent("tls.start", time.Now())
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			span.AddEvent("tls.done", time.Now(), "error", errString(err))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			span.AddEvent("connection", time.Now(), "reused", info.Reused)
+		},
+		GotFirstResponseByte: func() {
+			span.AddEvent("ttfb", time.Now())
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	if t.inject {
+		req.Header = req.Header.Clone()
+		req.Header.Set("traceparent", span.Context().Traceparent())
+	}
+
+	re, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err.Error())
+		span.End(time.Now())
+		return nil, err
+	}
+
+	span.SetAttr("http.status_code", re.StatusCode)
+	if re.StatusCode >= 500 {
+		span.SetError(re.Status)
+	}
+	if re.StatusCode == http.StatusSwitchingProtocols {
+		// NOTE: ReverseProxy needs the upgraded body to be io.ReadWriteCloser
+		span.End(time.Now())
+		return re, nil
+	}
+	re.Body = &spanBody{ReadCloser: re.Body, span: span}
+	return re, nil
+}
+
+// spanBody ends the span once the body is read or closed
+type spanBody struct {
+	io.ReadCloser
+	span *otlp.Span
+	once sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		if err != io.EOF {
+			b.span.SetError(err.Error())
+		}
+		b.end()
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	b.end()
+	return b.ReadCloser.Close()
+}
+
+func (b *spanBody) end() {
+	b.once.Do(func() { b.span.End(time.Now()) })
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,82 @@
+package cdpproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gmarik/cdp-proxy/otlp"
+)
+
+const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestTracingTransport(t *testing.T) {
+	var exported = make(chan []byte, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		exported <- data
+	}))
+	defer collector.Close()
+
+	var traceparents = make(chan string, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	for _, inject := range []bool{false, true} {
+		exp := otlp.NewExporter(collector.URL, "test-service")
+		tr := &tracingTransport{next: http.DefaultTransport, exp: exp, inject: inject}
+
+		req, _ := http.NewRequest("GET", upstream.URL, nil)
+		req.Header.Set("traceparent", incoming)
+		re, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(re.Body)
+		re.Body.Close()
+
+		if got := req.Header.Get("traceparent"); got != incoming {
+			t.Errorf("inject=%v: the caller's header changed: traceparent=%q", inject, got)
+		}
+
+		ctx, cancel_Fn := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := exp.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		cancel_Fn()
+
+		var payload struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						TraceID      string `json:"traceId"`
+						SpanID       string `json:"spanId"`
+						ParentSpanID string `json:"parentSpanId"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(<-exported, &payload); err != nil {
+			t.Fatal(err)
+		}
+		sp := payload.ResourceSpans[0].ScopeSpans[0].Spans[0]
+		if sp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || sp.ParentSpanID != "00f067aa0ba902b7" {
+			t.Errorf("inject=%v: the span doesn't continue the trace: traceId=%q parentSpanId=%q", inject, sp.TraceID, sp.ParentSpanID)
+		}
+
+		got := <-traceparents
+		switch {
+		case !inject && got != incoming:
+			t.Errorf("inject=false: upstream traceparent=%q, want the incoming %q", got, incoming)
+		case inject && got != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+sp.SpanID+"-01":
+			t.Errorf("inject=true: upstream traceparent=%q, want the span %q", got, sp.SpanID)
+		}
+	}
+}
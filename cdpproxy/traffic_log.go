@@ -0,0 +1,205 @@
+package cdpproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+)
+
+// TrafficLog writes a JSON object per completed request into a rotated file,
+// as an audit trail independent of the DevTools clients connected.
+// It subscribes to the event buses with Attach, e.g. `sessions.Subscribe(tl.Attach)`.
+type TrafficLog struct {
+	// Bodies includes the base64 encoded response bodies
+	Bodies bool
+
+	w  *rotatingFile
+	ch chan *trafficRecord
+
+	// readers are the event buses' readers attached, nil once closed
+	mu      sync.Mutex
+	readers map[*eventBusReader]struct{}
+	reading sync.WaitGroup
+	done    chan struct{}
+}
+
+// trafficRecord is a line of the log
+type trafficRecord struct {
+	Session         string          `json:"session"`
+	ID              string          `json:"id"`
+	StartedDateTime time.Time       `json:"startedDateTime"`
+	Method          string          `json:"method"`
+	URL             string          `json:"url"`
+	Status          int64           `json:"status"`
+	StatusText      string          `json:"statusText,omitempty"`
+	Protocol        string          `json:"protocol,omitempty"`
+	WaitMs          float64         `json:"waitMs"`
+	TimeMs          float64         `json:"timeMs"`
+	BodySize        int64           `json:"bodySize"`
+	EncodedSize     float64         `json:"encodedSize"`
+	RequestHeaders  network.Headers `json:"requestHeaders"`
+	ResponseHeaders network.Headers `json:"responseHeaders,omitempty"`
+	Failed          bool            `json:"failed,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	Body            string          `json:"body,omitempty"`
+
+	start, response time.Time
+}
+
+// NewTrafficLog appends to the file at path, rotating it at maxSize bytes or maxAge, if not zero.
+func NewTrafficLog(path string, maxSize int64, maxAge time.Duration) (*TrafficLog, error) {
+	w, err := openRotatingFile(path, maxSize, maxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	tl := &TrafficLog{
+		w:       w,
+		ch:      make(chan *trafficRecord, 1000),
+		readers: make(map[*eventBusReader]struct{}),
+		done:    make(chan struct{}),
+	}
+	go tl.write()
+	return tl, nil
+}
+
+func (tl *TrafficLog) write() {
+	defer close(tl.done)
+
+	enc := json.NewEncoder(tl.w)
+	enc.SetEscapeHTML(false)
+	encode := func(rec *trafficRecord) {
+		if err := enc.Encode(rec); err != nil {
+			log.Printf("TrafficLog: write: error=%q", err)
+		}
+	}
+
+	for rec := range tl.ch {
+		encode(rec)
+	}
+}
+
+// Attach records the requests of the event bus till the log is closed.
+// The log's reader is a queued one, it's never dropped for falling behind, e.g. while a write stalls,
+// the events beyond its queue's bound are dropped instead.
+func (tl *TrafficLog) Attach(name string, eb *EventBus) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if tl.readers == nil {
+		return
+	}
+	r := eb.newQueuedReader()
+	tl.readers[r] = struct{}{}
+
+	tl.reading.Add(1)
+	go func() {
+		defer tl.reading.Done()
+		defer r.Close()
+		tl.read(r, name, eb, make(map[string]*trafficRecord))
+	}()
+}
+
+// read records the events till the reader is closed and the events queued are read,
+// the requests still in flight then are recorded as failed
+func (tl *TrafficLog) read(r *eventBusReader, session string, eb *EventBus, pending map[string]*trafficRecord) {
+	for {
+		var e event
+		if err := r.ReadEvent(context.Background(), &e); err != nil {
+			var unfinished = make([]*trafficRecord, 0, len(pending))
+			for _, rec := range pending {
+				unfinished = append(unfinished, rec)
+			}
+			sort.Slice(unfinished, func(i, j int) bool { return unfinished[i].start.Before(unfinished[j].start) })
+			for _, rec := range unfinished {
+				rec.Failed, rec.Error = true, "unfinished when the log closed"
+				tl.finish(rec, time.Time{})
+			}
+			return
+		}
+
+		switch p := e.Params.(type) {
+		case requestWillBeSent:
+			if prev, ok := pending[string(p.RequestID)]; ok && p.RedirectResponse != nil {
+				// the request following the redirect finishes it
+				delete(pending, prev.ID)
+				tl.finish(prev, monotonic(p.Timestamp))
+			}
+			rec := &trafficRecord{Session: session, ID: string(p.RequestID), start: monotonic(p.Timestamp)}
+			if p.WallTime != nil {
+				rec.StartedDateTime = p.WallTime.Time()
+			}
+			if p.Request != nil {
+				rec.Method, rec.URL, rec.RequestHeaders = p.Request.Method, p.Request.URL, p.Request.Headers
+			}
+			pending[rec.ID] = rec
+		case network.EventResponseReceived:
+			if rec, ok := pending[string(p.RequestID)]; ok && p.Response != nil {
+				rec.response = monotonic(p.Timestamp)
+				rec.Status, rec.StatusText, rec.Protocol = p.Response.Status, p.Response.StatusText, p.Response.Protocol
+				rec.ResponseHeaders = p.Response.Headers
+			}
+		case network.EventDataReceived:
+			if rec, ok := pending[string(p.RequestID)]; ok {
+				rec.BodySize += p.DataLength
+			}
+		case network.EventLoadingFinished:
+			if rec, ok := pending[string(p.RequestID)]; ok {
+				delete(pending, rec.ID)
+				rec.EncodedSize = p.EncodedDataLength
+				if tl.Bodies {
+					if body, ok := eb.store.Load(rec.ID); ok && len(body) > 0 {
+						rec.Body = base64.StdEncoding.EncodeToString(body)
+					}
+				}
+				tl.finish(rec, monotonic(p.Timestamp))
+			}
+		case network.EventLoadingFailed:
+			if rec, ok := pending[string(p.RequestID)]; ok {
+				delete(pending, rec.ID)
+				rec.Failed, rec.Error = true, p.ErrorText
+				tl.finish(rec, monotonic(p.Timestamp))
+			}
+		}
+	}
+}
+
+func (tl *TrafficLog) finish(rec *trafficRecord, end time.Time) {
+	if !rec.response.IsZero() {
+		rec.WaitMs = millis(rec.response.Sub(rec.start))
+	}
+	if !end.IsZero() {
+		rec.TimeMs = millis(end.Sub(rec.start))
+	}
+	tl.ch <- rec
+}
+
+// Close stops recording: it detaches from the event buses, writes the records of the events queued by then,
+// the unfinished requests as failed, and closes the log.
+func (tl *TrafficLog) Close() error {
+	tl.mu.Lock()
+	readers := tl.readers
+	tl.readers = nil
+	tl.mu.Unlock()
+
+	for r := range readers {
+		r.Close()
+	}
+	tl.reading.Wait()
+	close(tl.ch)
+	<-tl.done
+	return tl.w.Close()
+}
+
+func monotonic(t *cdp.MonotonicTime) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.Time()
+}
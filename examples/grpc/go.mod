@@ -0,0 +1,15 @@
+module github.com/gmarik/cdp-proxy/examples/grpc
+
+go 1.13
+
+require (
+	github.com/gmarik/cdp-proxy v0.0.0
+	github.com/gmarik/cdp-proxy/grpc v0.0.0
+	golang.org/x/sys v0.0.0-20191204072324-ce4227a45e2e
+	google.golang.org/grpc v1.29.1
+)
+
+replace (
+	github.com/gmarik/cdp-proxy => ../../
+	github.com/gmarik/cdp-proxy/grpc => ../../grpc
+)
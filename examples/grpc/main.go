@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/gmarik/cdp-proxy/cdpproxy"
+	grpcx "github.com/gmarik/cdp-proxy/grpc"
+)
+
+var (
+	HTTP_CDP_HostPort = "localhost:9229"
+	GRPC_HostPort     = "localhost:50051"
+)
+
+// the health service called every second, both the server and the client sides recorded
+func main() {
+	flag.StringVar(&HTTP_CDP_HostPort, "http-cdp-hostport", HTTP_CDP_HostPort, "chrome devtools protocol listener address(host:port)")
+	flag.StringVar(&GRPC_HostPort, "grpc-hostport", GRPC_HostPort, "gRPC listener address(host:port)")
+	flag.Parse()
+
+	var (
+		sessions       = cdpproxy.NewSessions(nil)
+		ctx, cancel_Fn = context.WithCancel(context.Background())
+	)
+	defer cancel_Fn()
+
+	go func() {
+		log.Printf("devtools: http.ListenAndServe: hostport=%q", HTTP_CDP_HostPort)
+		if err := cdpproxy.NewServer(HTTP_CDP_HostPort, sessions).ListenAndServe(ctx); err != nil {
+			log.Fatalf("devtools: http.ListenAndServe: error=%q", err)
+		}
+	}()
+
+	ln, err := net.Listen("tcp", GRPC_HostPort)
+	if err != nil {
+		log.Fatalf("grpc: net.Listen: error=%q", err)
+	}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcx.UnaryServerInterceptor(sessions)),
+		grpc.StreamInterceptor(grpcx.StreamServerInterceptor(sessions)),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() {
+		log.Printf("grpc: Serve: hostport=%q", GRPC_HostPort)
+		if err := srv.Serve(ln); err != nil {
+			log.Fatalf("grpc: Serve: error=%q", err)
+		}
+	}()
+	defer srv.GracefulStop()
+
+	cc, err := grpc.Dial(GRPC_HostPort,
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(grpcx.UnaryClientInterceptor(sessions)),
+		grpc.WithStreamInterceptor(grpcx.StreamClientInterceptor(sessions)),
+	)
+	if err != nil {
+		log.Fatalf("grpc: Dial: error=%q", err)
+	}
+	defer cc.Close()
+	go call(ctx, healthpb.NewHealthClient(cc))
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, unix.SIGTERM, unix.SIGINT)
+	defer signal.Stop(sigc)
+
+	log.Printf("os: signal=%v", <-sigc)
+}
+
+func call(ctx context.Context, c healthpb.HealthClient) {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		// the unknown service fails with NotFound
+		var service = ""
+		if i%3 == 2 {
+			service = "unknown"
+		}
+		if _, err := c.Check(ctx, &healthpb.HealthCheckRequest{Service: service}); err != nil {
+			log.Printf("grpc: Check: error=%q", err)
+		}
+
+		wctx, cancel_Fn := context.WithTimeout(ctx, 100*time.Millisecond)
+		w, err := c.Watch(wctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			log.Printf("grpc: Watch: error=%q", err)
+			cancel_Fn()
+			continue
+		}
+		for {
+			if _, err := w.Recv(); err != nil {
+				if err != io.EOF {
+					log.Printf("grpc: Watch: Recv: error=%q", err)
+				}
+				break
+			}
+		}
+		cancel_Fn()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -11,8 +12,7 @@ import (
 
 	"golang.org/x/sys/unix"
 
-	cdphttp "github.com/gmarik/cdp-proxy/http"
-	httpcdp "github.com/gmarik/cdp-proxy/main/cdp-proxy/httpcdp"
+	"github.com/gmarik/cdp-proxy/cdpproxy"
 )
 
 var (
@@ -25,35 +25,65 @@ func main() {
 	flag.StringVar(&HTTP_Proxy_HostPort, "http-proxy-hostport", HTTP_Proxy_HostPort, "HTTP proxy listener address(host:port)")
 	flag.Parse()
 
-	var eb = httpcdp.NewEventBus()
-	var ctx = context.Background()
+	var (
+		sessions       = cdpproxy.NewSessions(nil)
+		ctx, cancel_Fn = context.WithCancel(context.Background())
+		done           = make(chan struct{}, 2)
+	)
 
 	go func() {
+		defer func() { done <- struct{}{} }()
 		log.Printf("devtools: http.ListenAndServe: hostport=%q", HTTP_CDP_HostPort)
-		s := httpcdp.Server{
-			Eventbus: eb,
-			HostPort: HTTP_CDP_HostPort,
-		}
-		if err := s.ListenAndServe(ctx); err != nil {
+		if err := cdpproxy.NewServer(HTTP_CDP_HostPort, sessions).ListenAndServe(ctx); err != nil {
 			log.Fatalf("devtools: http.ListenAndServe: error=%q", err)
 		}
 	}()
 
 	go func() {
+		defer func() { done <- struct{}{} }()
+		// the outbound requests are recorded alongside the inbound ones
+		client := &http.Client{Transport: cdpproxy.Transport(sessions, nil)}
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			fmt.Fprintf(w, "hello world")
+			if r.URL.Path != "/outbound" {
+				fmt.Fprintf(w, "hello world")
+				return
+			}
+
+			// the request's context makes the outbound request its child, the initiator in DevTools
+			req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, "http://"+HTTP_Proxy_HostPort+"/", nil)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			io.Copy(w, resp.Body)
 		})
 
+		// the hello world service recorded, use cdpproxy.Proxy to record a forward proxy instead
 		log.Printf("proxy: http.ListenAndServe: hostport=%q", HTTP_Proxy_HostPort)
-		if err := http.ListenAndServe(HTTP_Proxy_HostPort, cdphttp.Handler(eb, handler)); err != nil {
+		// ConnContext numbers the connections, the Connection ID column
+		srv := &http.Server{Addr: HTTP_Proxy_HostPort, Handler: cdpproxy.Handler(sessions, handler), ConnContext: cdpproxy.ConnContext}
+		go func() {
+			<-ctx.Done()
+			srv.Shutdown(context.Background())
+		}()
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 			log.Fatalf("proxy: http.ListenAndServe: error=%q", err)
 		}
 	}()
 
-	sigc := make(chan os.Signal)
+	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, unix.SIGTERM, unix.SIGINT)
 	defer signal.Stop(sigc)
 
 	log.Printf("os: signal=%v", <-sigc)
+	cancel_Fn()
+	<-done
+	<-done
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/gmarik/cdp-proxy/cdpproxy"
+	sqlx "github.com/gmarik/cdp-proxy/sql"
+	"github.com/gmarik/cdp-proxy/sql/fakedriver"
+)
+
+var (
+	HTTP_CDP_HostPort   = "localhost:9229"
+	HTTP_Proxy_HostPort = "localhost:8081"
+)
+
+func main() {
+	flag.StringVar(&HTTP_CDP_HostPort, "http-cdp-hostport", HTTP_CDP_HostPort, "chrome devtools protocol listener address(host:port)")
+	flag.StringVar(&HTTP_Proxy_HostPort, "http-proxy-hostport", HTTP_Proxy_HostPort, "HTTP proxy listener address(host:port)")
+	flag.Parse()
+
+	var (
+		sessions       = cdpproxy.NewSessions(nil)
+		ctx, cancel_Fn = context.WithCancel(context.Background())
+		done           = make(chan struct{}, 2)
+	)
+
+	// the queries are recorded alongside the requests which made them
+	sqlx.Register("traced-fake", sessions, &fakedriver.Driver{})
+	db, err := sql.Open("traced-fake", "example")
+	if err != nil {
+		log.Fatalf("sql.Open: error=%q", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE users (id, name)"); err != nil {
+		log.Fatalf("sql.Exec: error=%q", err)
+	}
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		log.Printf("devtools: http.ListenAndServe: hostport=%q", HTTP_CDP_HostPort)
+		if err := cdpproxy.NewServer(HTTP_CDP_HostPort, sessions).ListenAndServe(ctx); err != nil {
+			log.Fatalf("devtools: http.ListenAndServe: error=%q", err)
+		}
+	}()
+
+	go func() {
+		defer func() { done <- struct{}{} }()
+		// GET /users lists the users, POST /users?id=1&name=x adds one, DELETE /users?id=1 removes it
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/users" {
+				http.NotFound(w, r)
+				return
+			}
+
+			var (
+				ctx = r.Context()
+				id  = r.FormValue("id")
+				err error
+			)
+			switch r.Method {
+			case http.MethodPost:
+				_, err = db.ExecContext(ctx, "INSERT INTO users VALUES (?, ?)", id, r.FormValue("name"))
+			case http.MethodDelete:
+				_, err = db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			rows, err := db.QueryContext(ctx, "SELECT id, name FROM users")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+
+			var users = make(map[string]string)
+			for rows.Next() {
+				var id, name string
+				if err := rows.Scan(&id, &name); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				users[id] = name
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(users)
+		})
+
+		log.Printf("proxy: http.ListenAndServe: hostport=%q", HTTP_Proxy_HostPort)
+		srv := &http.Server{Addr: HTTP_Proxy_HostPort, Handler: cdpproxy.Handler(sessions, handler), ConnContext: cdpproxy.ConnContext}
+		go func() {
+			<-ctx.Done()
+			srv.Shutdown(context.Background())
+		}()
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("proxy: http.ListenAndServe: error=%q", err)
+		}
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, unix.SIGTERM, unix.SIGINT)
+	defer signal.Stop(sigc)
+
+	log.Printf("os: signal=%v", <-sigc)
+	cancel_Fn()
+	<-done
+	<-done
+}
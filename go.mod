@@ -4,6 +4,7 @@ go 1.13
 
 require (
 	github.com/chromedp/cdproto v0.0.0-20191003000610-799a06e3acec
+	github.com/google/pprof v0.0.0-20200229191704-1ebb73c60ed3
 	github.com/gorilla/websocket v1.4.1
-	golang.org/x/sys v0.0.0-20191003212358-c178f38b412c
+	golang.org/x/sys v0.0.0-20191204072324-ce4227a45e2e
 )
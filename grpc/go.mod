@@ -0,0 +1,11 @@
+module github.com/gmarik/cdp-proxy/grpc
+
+go 1.13
+
+require (
+	github.com/gmarik/cdp-proxy v0.0.0
+	github.com/golang/protobuf v1.3.3
+	google.golang.org/grpc v1.29.1
+)
+
+replace github.com/gmarik/cdp-proxy => ../
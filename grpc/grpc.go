@@ -0,0 +1,322 @@
+// Package grpc traces the RPCs with the Tracer of package http, each RPC as a Network entry:
+// the method as the URL, the metadata as the headers, the messages as the JSON body chunks
+// and the status as the HTTP-like one.
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+)
+
+// UnaryServerInterceptor traces the unary RPCs served
+func UnaryServerInterceptor(trace httpx.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		r := newRequest(ctx, authority(md, ""), info.FullMethod, md, req)
+		reqID := trace.RequestWillBeSent(r)
+
+		resp, err := handler(ctx, req)
+		finishUnary(trace, reqID, r, resp, err, nil, nil)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor traces the streaming RPCs served, the messages both ways as the body chunks
+func StreamServerInterceptor(trace httpx.Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		r := newRequest(ss.Context(), authority(md, ""), info.FullMethod, md, nil)
+		s := &stream{trace: trace, req: r, reqID: trace.RequestWillBeSent(r)}
+
+		err := handler(srv, &serverStream{ServerStream: ss, s: s})
+		s.finish(err, nil)
+		return err
+	}
+}
+
+// UnaryClientInterceptor traces the unary RPCs called
+func UnaryClientInterceptor(trace httpx.Tracer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		r := newRequest(ctx, authority(md, cc.Target()), method, md, req)
+		reqID := trace.RequestWillBeSent(r)
+
+		var header, trailer metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+		finishUnary(trace, reqID, r, reply, err, header, trailer)
+		return err
+	}
+}
+
+// StreamClientInterceptor traces the streaming RPCs called, the messages both ways as the body chunks.
+// The RPC finishes once the stream is read till the end, or the response is received if not server streaming, or fails.
+func StreamClientInterceptor(trace httpx.Tracer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		r := newRequest(ctx, authority(md, cc.Target()), method, md, nil)
+		s := &stream{trace: trace, req: r, reqID: trace.RequestWillBeSent(r)}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			s.finish(err, nil)
+			return nil, err
+		}
+		return &clientStream{ClientStream: cs, s: s, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+func finishUnary(trace httpx.Tracer, reqID string, r *http.Request, resp interface{}, err error, header, trailer metadata.MD) {
+	re := newResponse(r, err, header, trailer)
+	var body []byte
+	if err == nil {
+		body = marshal(resp)
+		re.ContentLength = int64(len(body))
+	}
+
+	trace.ResponseReceived(reqID, re)
+	if len(body) > 0 {
+		trace.DataReceived(reqID, body)
+	}
+	trace.LoadingFinished(reqID, re)
+}
+
+// stream reports the messages of a streaming RPC, the response is received with the first message
+type stream struct {
+	trace httpx.Tracer
+	req   *http.Request
+	reqID string
+
+	mu   sync.Mutex
+	re   *http.Response
+	size int64
+	done bool
+}
+
+func (s *stream) message(m interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	if s.re == nil {
+		s.re = newResponse(s.req, nil, nil, nil)
+		s.trace.ResponseReceived(s.reqID, s.re)
+	}
+	body := marshal(m)
+	s.size += int64(len(body))
+	s.trace.DataReceived(s.reqID, body)
+}
+
+func (s *stream) finish(err error, trailer metadata.MD) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+
+	if s.re == nil {
+		// no messages, so the status is known
+		s.re = newResponse(s.req, err, nil, trailer)
+		s.trace.ResponseReceived(s.reqID, s.re)
+	} else if err != nil {
+		s.trace.LoadingFailed(s.reqID, s.req, err)
+		return
+	}
+	s.re.ContentLength = s.size
+	s.trace.LoadingFinished(s.reqID, s.re)
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	s *stream
+}
+
+func (ss *serverStream) SendMsg(m interface{}) error {
+	err := ss.ServerStream.SendMsg(m)
+	if err == nil {
+		ss.s.message(m)
+	}
+	return err
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	err := ss.ServerStream.RecvMsg(m)
+	if err == nil {
+		ss.s.message(m)
+	}
+	return err
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	s *stream
+	// serverStreams is false for the RPCs done with the single response, e.g. CloseAndRecv
+	serverStreams bool
+}
+
+func (cs *clientStream) SendMsg(m interface{}) error {
+	err := cs.ClientStream.SendMsg(m)
+	switch {
+	case err == nil:
+		cs.s.message(m)
+	case err != io.EOF:
+		// NOTE: io.EOF means the stream failed, reported by RecvMsg
+		cs.s.finish(err, nil)
+	}
+	return err
+}
+
+func (cs *clientStream) RecvMsg(m interface{}) error {
+	err := cs.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		cs.s.message(m)
+		if !cs.serverStreams {
+			// NOTE: grpc has received the status already, there's no RecvMsg returning io.EOF to come
+			cs.s.finish(nil, cs.Trailer())
+		}
+	case err == io.EOF:
+		cs.s.finish(nil, cs.Trailer())
+	default:
+		cs.s.finish(err, cs.Trailer())
+	}
+	return err
+}
+
+// newRequest is the RPC as a request to `grpc://<authority>/<service>/<method>`
+func newRequest(ctx context.Context, authority, method string, md metadata.MD, msg interface{}) *http.Request {
+	var u = &url.URL{Scheme: "grpc", Host: authority, Path: method}
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        u,
+		Host:       authority,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+		RequestURI: method,
+	}
+	r = r.WithContext(ctx)
+	for k, vs := range md {
+		if strings.HasPrefix(k, ":") {
+			continue
+		}
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	r.Header.Set("Content-Type", "application/grpc")
+
+	if msg != nil {
+		body := marshal(msg)
+		r.ContentLength = int64(len(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return r
+}
+
+// newResponse is the RPC's status as an HTTP-like response, the metadata as the headers
+func newResponse(r *http.Request, err error, header, trailer metadata.MD) *http.Response {
+	st := status.Convert(err)
+	re := &http.Response{
+		Request:    r,
+		StatusCode: httpStatus(st.Code()),
+		Status:     st.Code().String(),
+		Proto:      r.Proto,
+		ProtoMajor: r.ProtoMajor,
+		Header:     make(http.Header),
+	}
+	for _, md := range []metadata.MD{header, trailer} {
+		for k, vs := range md {
+			for _, v := range vs {
+				re.Header.Add(k, v)
+			}
+		}
+	}
+	re.Header.Set("Content-Type", "application/grpc")
+	re.Header.Set("Grpc-Status", st.Code().String())
+	if st.Message() != "" {
+		re.Header.Set("Grpc-Message", st.Message())
+	}
+	return re
+}
+
+// httpStatus maps the code like the gRPC gateways do
+// https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	// Unknown, Internal, DataLoss
+	return http.StatusInternalServerError
+}
+
+func authority(md metadata.MD, target string) string {
+	if vs := md.Get(":authority"); len(vs) > 0 {
+		return vs[0]
+	}
+	if target != "" {
+		return target
+	}
+	return "localhost"
+}
+
+var jsonpbMarshaler = jsonpb.Marshaler{OrigName: true}
+
+// marshal encodes the message as a JSON line
+func marshal(m interface{}) []byte {
+	var (
+		buf bytes.Buffer
+		err error
+	)
+	if pm, ok := m.(proto.Message); ok {
+		err = jsonpbMarshaler.Marshal(&buf, pm)
+		buf.WriteByte('\n')
+	} else {
+		err = json.NewEncoder(&buf).Encode(m)
+	}
+	if err != nil {
+		return []byte(fmt.Sprintf("(%v)\n", err))
+	}
+	return buf.Bytes()
+}
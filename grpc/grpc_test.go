@@ -0,0 +1,352 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// recorder is the Tracer keeping the entries
+type recorder struct {
+	mu      sync.Mutex
+	entries []*entry
+}
+
+type entry struct {
+	req    *http.Request
+	re     *http.Response
+	chunks []string
+	done   bool
+	err    error
+}
+
+func (rec *recorder) RequestWillBeSent(r *http.Request) string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entries = append(rec.entries, &entry{req: r})
+	return fmt.Sprint(len(rec.entries) - 1)
+}
+
+func (rec *recorder) entry(reqID string) *entry {
+	var i int
+	fmt.Sscan(reqID, &i)
+	return rec.entries[i]
+}
+
+func (rec *recorder) ResponseReceived(reqID string, re *http.Response) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entry(reqID).re = re
+}
+
+func (rec *recorder) DataReceived(reqID string, p []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	e := rec.entry(reqID)
+	e.chunks = append(e.chunks, strings.TrimSpace(string(p)))
+}
+
+func (rec *recorder) LoadingFinished(reqID string, re *http.Response) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entry(reqID).done = true
+}
+
+func (rec *recorder) LoadingFailed(reqID string, r *http.Request, err error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	e := rec.entry(reqID)
+	e.done, e.err = true, err
+}
+
+// last is the only entry recorded
+func (rec *recorder) last(t *testing.T) entry {
+	t.Helper()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.entries) != 1 {
+		t.Fatalf("entries=%d, want 1", len(rec.entries))
+	}
+	return *rec.entries[0]
+}
+
+// echo is the test service, with the health messages so there's no code to generate
+var echo = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Check",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(healthpb.HealthCheckRequest)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				grpc.SetHeader(ctx, metadata.Pairs("x-served-by", "echo"))
+				if req.(*healthpb.HealthCheckRequest).Service == "missing" {
+					return nil, status.Error(codes.NotFound, "no such service")
+				}
+				return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Echo/Check"}, handler)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Collect",
+		ClientStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			var n int
+			for {
+				var m healthpb.HealthCheckRequest
+				if err := ss.RecvMsg(&m); err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				n++
+			}
+			return ss.SendMsg(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_ServingStatus(n)})
+		},
+	}, {
+		StreamName:    "Watch",
+		ServerStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			var m healthpb.HealthCheckRequest
+			if err := ss.RecvMsg(&m); err != nil {
+				return err
+			}
+			for i := 0; i < 3; i++ {
+				if err := ss.SendMsg(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}); err != nil {
+					return err
+				}
+				if m.Service == "broken" {
+					return status.Error(codes.Internal, "broken")
+				}
+			}
+			return nil
+		},
+	}},
+}
+
+// dial serves the echo service over bufconn, both sides recorded
+func dial(t *testing.T) (cc *grpc.ClientConn, client, server *recorder, stop func()) {
+	client, server = new(recorder), new(recorder)
+
+	ln := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(server)),
+		grpc.StreamInterceptor(StreamServerInterceptor(server)),
+	)
+	srv.RegisterService(&echo, struct{}{})
+	go srv.Serve(ln)
+
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.Dial() }),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(client)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(client)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, client, server, func() {
+		cc.Close()
+		srv.Stop()
+	}
+}
+
+func testContext() (context.Context, context.CancelFunc) {
+	ctx, cancel_Fn := context.WithTimeout(context.Background(), 5*time.Second)
+	return metadata.AppendToOutgoingContext(ctx, "x-request", "test"), cancel_Fn
+}
+
+func TestUnary(t *testing.T) {
+	cc, client, server, stop := dial(t)
+	defer stop()
+	ctx, cancel_Fn := testContext()
+	defer cancel_Fn()
+
+	var resp healthpb.HealthCheckResponse
+	if err := cc.Invoke(ctx, "/test.Echo/Check", &healthpb.HealthCheckRequest{Service: "db"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for side, rec := range map[string]*recorder{"client": client, "server": server} {
+		e := rec.last(t)
+		if got := e.req.URL.String(); got != "grpc://bufnet/test.Echo/Check" {
+			t.Errorf("%s: url=%q", side, got)
+		}
+		if got := e.req.Header.Get("X-Request"); got != "test" {
+			t.Errorf("%s: x-request=%q", side, got)
+		}
+		if body, _ := e.req.GetBody(); body == nil {
+			t.Errorf("%s: no post data", side)
+		}
+		if e.re == nil || e.re.StatusCode != http.StatusOK || e.re.Header.Get("Grpc-Status") != "OK" {
+			t.Fatalf("%s: response=%+v", side, e.re)
+		}
+		if want := []string{`{"status":"SERVING"}`}; fmt.Sprint(e.chunks) != fmt.Sprint(want) {
+			t.Errorf("%s: chunks=%q, want %q", side, e.chunks, want)
+		}
+		if !e.done || e.err != nil {
+			t.Errorf("%s: done=%v error=%v", side, e.done, e.err)
+		}
+	}
+	if got := client.last(t).re.Header.Get("X-Served-By"); got != "echo" {
+		t.Errorf("client: x-served-by=%q", got)
+	}
+}
+
+func TestUnary_error(t *testing.T) {
+	cc, client, server, stop := dial(t)
+	defer stop()
+	ctx, cancel_Fn := testContext()
+	defer cancel_Fn()
+
+	var resp healthpb.HealthCheckResponse
+	err := cc.Invoke(ctx, "/test.Echo/Check", &healthpb.HealthCheckRequest{Service: "missing"}, &resp)
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("error=%v", err)
+	}
+
+	for side, rec := range map[string]*recorder{"client": client, "server": server} {
+		e := rec.last(t)
+		if e.re == nil || e.re.StatusCode != http.StatusNotFound ||
+			e.re.Header.Get("Grpc-Status") != "NotFound" || e.re.Header.Get("Grpc-Message") != "no such service" {
+			t.Fatalf("%s: response=%+v", side, e.re)
+		}
+		if len(e.chunks) != 0 || !e.done {
+			t.Errorf("%s: chunks=%q done=%v", side, e.chunks, e.done)
+		}
+	}
+}
+
+func TestClientStreaming(t *testing.T) {
+	cc, client, server, stop := dial(t)
+	defer stop()
+	ctx, cancel_Fn := testContext()
+	defer cancel_Fn()
+
+	cs, err := cc.NewStream(ctx, &echo.Streams[0], "/test.Echo/Collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := cs.SendMsg(&healthpb.HealthCheckRequest{Service: fmt.Sprint("s", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// CloseAndRecv, no RecvMsg returning io.EOF follows
+	if err := cs.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	var resp healthpb.HealthCheckResponse
+	if err := cs.RecvMsg(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for side, rec := range map[string]*recorder{"client": client, "server": server} {
+		e := rec.last(t)
+		if !e.done || e.err != nil {
+			t.Errorf("%s: done=%v error=%v", side, e.done, e.err)
+		}
+		want := []string{`{"service":"s0"}`, `{"service":"s1"}`, `{"service":"s2"}`, `{"status":"SERVICE_UNKNOWN"}`}
+		if fmt.Sprint(e.chunks) != fmt.Sprint(want) {
+			t.Errorf("%s: chunks=%q, want %q", side, e.chunks, want)
+		}
+		if e.re == nil || e.re.StatusCode != http.StatusOK {
+			t.Errorf("%s: response=%+v", side, e.re)
+		}
+	}
+}
+
+func TestServerStreaming(t *testing.T) {
+	cc, client, server, stop := dial(t)
+	defer stop()
+	ctx, cancel_Fn := testContext()
+	defer cancel_Fn()
+
+	cs, err := cc.NewStream(ctx, &echo.Streams[1], "/test.Echo/Watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SendMsg(&healthpb.HealthCheckRequest{Service: "db"}); err != nil {
+		t.Fatal(err)
+	}
+	cs.CloseSend()
+
+	var n int
+	for {
+		var resp healthpb.HealthCheckResponse
+		err := cs.RecvMsg(&resp)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if e := client.last(t); e.done {
+			t.Fatalf("client: finished after the message %d", n)
+		}
+	}
+	if n != 3 {
+		t.Fatalf("messages=%d", n)
+	}
+
+	for side, rec := range map[string]*recorder{"client": client, "server": server} {
+		e := rec.last(t)
+		if !e.done || e.err != nil {
+			t.Errorf("%s: done=%v error=%v", side, e.done, e.err)
+		}
+		if len(e.chunks) != 4 || e.chunks[0] != `{"service":"db"}` {
+			t.Errorf("%s: chunks=%q", side, e.chunks)
+		}
+	}
+}
+
+func TestServerStreaming_error(t *testing.T) {
+	cc, client, server, stop := dial(t)
+	defer stop()
+	ctx, cancel_Fn := testContext()
+	defer cancel_Fn()
+
+	cs, err := cc.NewStream(ctx, &echo.Streams[1], "/test.Echo/Watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SendMsg(&healthpb.HealthCheckRequest{Service: "broken"}); err != nil {
+		t.Fatal(err)
+	}
+	cs.CloseSend()
+
+	for {
+		var resp healthpb.HealthCheckResponse
+		if err = cs.RecvMsg(&resp); err != nil {
+			break
+		}
+	}
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("error=%v", err)
+	}
+
+	// the response was received with the first message, so the status fails the entry
+	for side, rec := range map[string]*recorder{"client": client, "server": server} {
+		if e := rec.last(t); !e.done || status.Code(e.err) != codes.Internal {
+			t.Errorf("%s: done=%v error=%v", side, e.done, e.err)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnInfo describes the connection a traced request is served or sent over
+type ConnInfo struct {
+	// ID numbers the connections, 0 if unknown
+	ID uint64
+	// Reused reports whether the connection carried the earlier requests, e.g. a keep-alive one
+	Reused bool
+	// RemoteAddr is the client's address of the served requests, the server's of the sent ones
+	RemoteAddr string
+
+	// reqID is the request the connection info belongs to, not the requests sent with its context
+	reqID string
+	// conn is the client connection the request is sent over
+	conn net.Conn
+}
+
+type connInfoKey struct{}
+
+// Conn returns the connection info of the traced request reqID, if known
+func Conn(ctx context.Context, reqID string) (ConnInfo, bool) {
+	ci, ok := ctx.Value(connInfoKey{}).(*ConnInfo)
+	if !ok || ci.reqID != reqID {
+		return ConnInfo{}, false
+	}
+	return *ci, true
+}
+
+var connIDs uint64
+
+// connState counts the requests served over the connection
+type connState struct {
+	id       uint64
+	requests int64
+
+	// redirect is the last redirect response served, for the client following it on the same connection
+	mu       sync.Mutex
+	redirect *http.Response
+}
+
+type connStateKey struct{}
+
+// ConnContext numbers the connections served, to be set as the http.Server's ConnContext,
+// so that the requests the Handler traces report their connection and its reuse
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connStateKey{}, &connState{id: atomic.AddUint64(&connIDs, 1)})
+}
+
+// ServedConnID is the ID of the connection the request with the ctx is served over, 0 if unknown or a client one
+func ServedConnID(ctx context.Context) uint64 {
+	if cs, ok := ctx.Value(connStateKey{}).(*connState); ok {
+		return cs.id
+	}
+	return 0
+}
+
+// servedConn is the connection info of the request served
+func servedConn(r *http.Request, reqID string) *ConnInfo {
+	var ci = &ConnInfo{RemoteAddr: r.RemoteAddr, reqID: reqID}
+	if cs, ok := r.Context().Value(connStateKey{}).(*connState); ok {
+		ci.ID = cs.id
+		ci.Reused = atomic.AddInt64(&cs.requests, 1) > 1
+	}
+	return ci
+}
+
+// served records the response served over the request's connection
+func (cs *connState) served(re *http.Response) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.redirect = nil
+	if IsRedirect(re) {
+		cs.redirect = re
+	}
+}
+
+// followedRedirect is the request with the redirect response it follows as the Response, like the client's redirects,
+// if the previous request served over the connection redirected to it
+func followedRedirect(r *http.Request) *http.Request {
+	cs, ok := r.Context().Value(connStateKey{}).(*connState)
+	if !ok {
+		return r
+	}
+	cs.mu.Lock()
+	re := cs.redirect
+	cs.redirect = nil
+	cs.mu.Unlock()
+
+	if re == nil || !redirectsTo(re, r) {
+		return r
+	}
+	r = r.WithContext(r.Context())
+	r.Response = re
+	return r
+}
+
+// IsRedirect reports whether the response redirects to its Location
+func IsRedirect(re *http.Response) bool {
+	switch re.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return re.Header.Get("Location") != ""
+	}
+	return false
+}
+
+// redirectsTo reports whether the redirect response's Location is the request's URL
+func redirectsTo(re *http.Response, r *http.Request) bool {
+	loc, err := re.Request.URL.Parse(re.Header.Get("Location"))
+	if err != nil {
+		return false
+	}
+	var host = r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	if loc.Host != "" && !strings.EqualFold(loc.Host, host) {
+		return false
+	}
+	return loc.EscapedPath() == r.URL.EscapedPath() && loc.RawQuery == r.URL.RawQuery
+}
+
+// maxIdleConns is the number of the idle client connections to remember, http.DefaultTransport's MaxIdleConns
+const maxIdleConns = 100
+
+// clientConns numbers the client connections, remembering the ones with the requests in flight
+// and the most recently idle ones, to be reused
+type clientConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]*clientConn
+	// idle are the connections without the requests in flight, the oldest first
+	idle []net.Conn
+}
+
+type clientConn struct {
+	id       uint64
+	inflight int
+}
+
+// sentConn traces the connection the request is sent over into its connection info, see done
+func (cc *clientConns) sentConn(req *http.Request, reqID string) *http.Request {
+	var ci = &ConnInfo{reqID: reqID}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			ci.Reused = info.Reused
+			ci.RemoteAddr = info.Conn.RemoteAddr().String()
+
+			cc.mu.Lock()
+			defer cc.mu.Unlock()
+			if cc.conns == nil {
+				cc.conns = make(map[net.Conn]*clientConn)
+			}
+			c, ok := cc.conns[info.Conn]
+			if !ok || !info.Reused {
+				c = &clientConn{id: atomic.AddUint64(&connIDs, 1)}
+				cc.conns[info.Conn] = c
+			}
+			if c.inflight == 0 {
+				cc.removeIdle(info.Conn)
+			}
+			c.inflight++
+			ci.ID, ci.conn = c.id, info.Conn
+		},
+	}
+	ctx := httptrace.WithClientTrace(req.Context(), trace)
+	return req.WithContext(context.WithValue(ctx, connInfoKey{}, ci))
+}
+
+// done forgets the request's connection once it has no requests in flight and it's closing,
+// e.g. the request failed or the response closes it, or it's the oldest of the idle ones over maxIdleConns
+func (cc *clientConns) done(req *http.Request, closing bool) {
+	ci, ok := req.Context().Value(connInfoKey{}).(*ConnInfo)
+	if !ok || ci.conn == nil {
+		return
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	c, ok := cc.conns[ci.conn]
+	if !ok || c.id != ci.ID {
+		return
+	}
+	if c.inflight--; c.inflight > 0 {
+		return
+	}
+	if closing {
+		delete(cc.conns, ci.conn)
+		return
+	}
+	cc.idle = append(cc.idle, ci.conn)
+	if len(cc.idle) > maxIdleConns {
+		delete(cc.conns, cc.idle[0])
+		cc.idle = cc.idle[1:]
+	}
+}
+
+func (cc *clientConns) removeIdle(conn net.Conn) {
+	for i, c := range cc.idle {
+		if c == conn {
+			cc.idle = append(cc.idle[:i], cc.idle[i+1:]...)
+			return
+		}
+	}
+}
@@ -2,25 +2,52 @@ package http
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"time"
 )
 
+// Tracer records the requests served by the Handler as the Network domain events
 // https://chromedevtools.github.io/devtools-protocol/1-2/Network
-type tracer interface {
+// The requests following a redirect, sent by http.Client or served over the connection the redirect was served over,
+// have the redirect response as the Response, so that the tracer can report the chain as the same request.
+type Tracer interface {
 	RequestWillBeSent(req *http.Request) (reqID string)
 	ResponseReceived(reqID string, req *http.Response)
 	DataReceived(reqID string, data []byte)
 	LoadingFinished(reqID string, req *http.Response)
-	LoadingFailed(reqID string, req *http.Request)
+	LoadingFailed(reqID string, req *http.Request, err error)
 }
 
-func Handler(trace tracer, next http.Handler) http.Handler {
+type reqIDKey struct{}
+
+// RequestID returns the ID of the traced request ctx belongs to, if any.
+// The requests traced with ctx, e.g. sent with Transport while serving the request, are its children.
+func RequestID(ctx context.Context) string {
+	reqID, _ := ctx.Value(reqIDKey{}).(string)
+	return reqID
+}
+
+// Handler traces the requests served by next
+func Handler(trace Tracer, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqID := trace.RequestWillBeSent(r)
+		var start = time.Now()
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+
+		// NOTE: the follow-up of a redirect is reported as the redirected request, the Response is set just for the tracer
+		reqID := trace.RequestWillBeSent(followedRedirect(r))
+		ctx := context.WithValue(r.Context(), reqIDKey{}, reqID)
+		r = r.WithContext(context.WithValue(ctx, connInfoKey{}, servedConn(r, reqID)))
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = countingBody{r.Body}
+		}
 		defer func() {
 			if perr := recover(); perr != nil {
-				trace.LoadingFailed(reqID, r)
+				trace.LoadingFailed(reqID, r, fmt.Errorf("panic: %v", perr))
+				observe(r, 0, start)
 				// bubble-up
 				panic(perr)
 			}
@@ -31,6 +58,7 @@ func Handler(trace tracer, next http.Handler) http.Handler {
 				ResponseWriter: w,
 				tracer:         trace,
 				reqID:          reqID,
+				req:            r,
 			}
 		)
 
@@ -38,8 +66,14 @@ func Handler(trace tracer, next http.Handler) http.Handler {
 
 		re := rw.response(r)
 
-		trace.ResponseReceived(reqID, re)
+		if !rw.responded {
+			trace.ResponseReceived(reqID, re)
+		}
 		trace.LoadingFinished(reqID, re)
+		if cs, ok := r.Context().Value(connStateKey{}).(*connState); ok {
+			cs.served(re)
+		}
+		observe(r, re.StatusCode, start)
 	})
 }
 
@@ -50,7 +84,11 @@ type responseWriter struct {
 	contentLength int64
 
 	reqID  string
-	tracer tracer
+	tracer Tracer
+
+	// req is the request served, responded reports whether the response headers were sent and traced
+	req       *http.Request
+	responded bool
 }
 
 func (w *responseWriter) response(r *http.Request) *http.Response {
@@ -68,11 +106,22 @@ func (w *responseWriter) response(r *http.Request) *http.Response {
 	}
 }
 
-func (w *responseWriter) Write(p []byte) (n int, err error) {
+// respond traces the response as the headers are sent, so that its time is the first byte's rather than the last one's
+func (w *responseWriter) respond() {
 	if w.status == 0 {
 		w.status = http.StatusOK
 	}
+	if w.responded {
+		return
+	}
+	w.responded = true
+	w.tracer.ResponseReceived(w.reqID, w.response(w.req))
+}
+
+func (w *responseWriter) Write(p []byte) (n int, err error) {
+	w.respond()
 	w.contentLength += int64(len(p))
+	bytesTotal.WithLabelValues("out").Add(float64(len(p)))
 	w.tracer.DataReceived(w.reqID, copySlice(p))
 	return w.ResponseWriter.Write(p)
 }
@@ -82,13 +131,14 @@ func (w *responseWriter) WriteHeader(code int) {
 	if w.status > 0 {
 		return
 	}
-	w.tracer.DataReceived(w.reqID, nil)
 	w.status = code
+	w.respond()
+	w.tracer.DataReceived(w.reqID, nil)
 }
 
 type conn struct {
 	net.Conn
-	tracer tracer
+	tracer Tracer
 	reqID  string
 }
 
@@ -112,6 +162,7 @@ func (c *conn) CloseWrite() error {
 }
 func (w *responseWriter) Flush() {
 	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.respond()
 		f.Flush()
 		return
 	}
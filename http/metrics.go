@@ -0,0 +1,40 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gmarik/cdp-proxy/metrics"
+)
+
+// Metrics are the traced requests' metrics, exposed once registered, e.g. `metrics.Default.Register(http.Metrics)`
+var Metrics = new(metrics.Registry)
+
+var (
+	requestsTotal = Metrics.NewCounterVec("cdp_proxy_requests_total",
+		"Traced requests by method and status.", "method", "status")
+	requestDuration = Metrics.NewHistogramVec("cdp_proxy_request_duration_seconds",
+		"Traced requests latency by method.", metrics.DefBuckets, "method")
+	requestsInFlight = Metrics.NewGauge("cdp_proxy_requests_in_flight",
+		"Traced requests being served.")
+	bytesTotal = Metrics.NewCounterVec("cdp_proxy_http_bytes_total",
+		"Traced request(in) and response(out) body bytes.", "direction")
+)
+
+func observe(r *http.Request, status int, start time.Time) {
+	requestsTotal.WithLabelValues(r.Method, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(r.Method).Observe(time.Since(start).Seconds())
+}
+
+// countingBody counts the request body bytes read
+type countingBody struct {
+	io.ReadCloser
+}
+
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	bytesTotal.WithLabelValues("in").Add(float64(n))
+	return n, err
+}
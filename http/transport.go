@@ -0,0 +1,99 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Transport traces the client requests sent with next, http.DefaultTransport if nil:
+// the request, the response headers, the body chunks as read, and the errors
+func Transport(trace Tracer, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{tracer: trace, next: next}
+}
+
+type transport struct {
+	tracer Tracer
+	next   http.RoundTripper
+	conns  clientConns
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqID := t.tracer.RequestWillBeSent(req)
+	req = req.WithContext(context.WithValue(req.Context(), reqIDKey{}, reqID))
+	req = t.conns.sentConn(req, reqID)
+
+	re, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.conns.done(req, true)
+		t.tracer.LoadingFailed(reqID, req, err)
+		return nil, err
+	}
+	if re.Request == nil {
+		re.Request = req
+	}
+	t.tracer.ResponseReceived(reqID, re)
+
+	// NOTE: the upgraded body is io.ReadWriteCloser, not to be wrapped
+	if re.Body == nil || re.Body == http.NoBody || re.StatusCode == http.StatusSwitchingProtocols {
+		// NOTE: the upgraded connection is the caller's
+		t.conns.done(req, re.Close || re.StatusCode == http.StatusSwitchingProtocols)
+		t.tracer.LoadingFinished(reqID, re)
+		return re, nil
+	}
+	re.Body = &body{ReadCloser: re.Body, tracer: t.tracer, conns: &t.conns, reqID: reqID, re: re, req: req}
+	return re, nil
+}
+
+// body reports the chunks as they're read, and finishes the loading at EOF or Close, or fails it on the read error
+type body struct {
+	io.ReadCloser
+	tracer Tracer
+	conns  *clientConns
+	reqID  string
+	re     *http.Response
+	req    *http.Request
+	read   int64
+	once   sync.Once
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if n > 0 {
+		b.tracer.DataReceived(b.reqID, copySlice(p[:n]))
+	}
+	switch {
+	case err == io.EOF:
+		b.finish(nil, false)
+	case err != nil:
+		b.finish(err, true)
+	}
+	return n, err
+}
+
+// Close finishes the loading with the bytes read, e.g. http.Client closes the redirects' bodies unread.
+// The connection of the body not read to the end is closed.
+func (b *body) Close() error {
+	// NOTE: the callers reading exactly ContentLength bytes, e.g. io.ReadFull, close the body without hitting EOF
+	b.finish(nil, b.re.ContentLength < 0 || b.read != b.re.ContentLength)
+	return b.ReadCloser.Close()
+}
+
+func (b *body) finish(err error, closing bool) {
+	b.once.Do(func() {
+		b.conns.done(b.req, closing || b.re.Close)
+		if err != nil {
+			b.tracer.LoadingFailed(b.reqID, b.re.Request, err)
+			return
+		}
+		// the response as received, the body's length as read
+		re := *b.re
+		re.ContentLength = b.read
+		b.tracer.LoadingFinished(b.reqID, &re)
+	})
+}
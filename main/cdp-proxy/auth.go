@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gmarik/cdp-proxy/cdpproxy"
+)
+
+// parseUsers parses the comma separated `user:password` pairs
+func parseUsers(s string) (map[string]string, error) {
+	var users = make(map[string]string)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		i := strings.Index(v, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid user:password %q", v)
+		}
+		users[v[:i]] = v[i+1:]
+	}
+	return users, nil
+}
+
+// proxyAuth serves only the clients from the allowed IPs, if any,
+// with the Proxy-Authorization Basic credentials of one of the users, if any.
+// The Proxy-Authorization is removed once checked, not to be recorded, the user is kept for SessionByUser.
+func proxyAuth(users map[string]string, ips []*net.IPNet, logger *cdpproxy.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cdpproxy.AllowedIP(ips, r.RemoteAddr) {
+			logger.Warnf("[proxy] auth: client IP not allowed: remote=%q host=%q", r.RemoteAddr, r.Host)
+			http.Error(w, "client IP not allowed", http.StatusForbidden)
+			return
+		}
+
+		user, pass, ok := (&http.Request{Header: http.Header{
+			"Authorization": r.Header["Proxy-Authorization"],
+		}}).BasicAuth()
+		if len(users) > 0 {
+			if want, found := users[user]; !ok || !found || !cdpproxy.SecureCompare(pass, want) {
+				logger.Warnf("[proxy] auth: unauthorized: remote=%q host=%q user=%q", r.RemoteAddr, r.Host, user)
+				w.Header().Set("Proxy-Authenticate", `Basic realm="cdp-proxy"`)
+				http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+				return
+			}
+		}
+
+		if _, found := r.Header["Proxy-Authorization"]; found {
+			r.Header.Del("Proxy-Authorization")
+			if ok {
+				r = cdpproxy.WithProxyUser(r, user)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
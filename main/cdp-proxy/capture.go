@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+)
+
+// capturedRequest is a request assembled from the Network events
+type capturedRequest struct {
+	ID             string
+	Method         string
+	URL            string
+	RequestHeaders network.Headers
+	PostData       string
+
+	Status          int64
+	StatusText      string
+	Protocol        string
+	ResponseHeaders network.Headers
+	// Size is the decoded body size
+	Size int64
+
+	Start, End time.Time
+	Done       bool
+	Failed     bool
+	ErrorText  string
+}
+
+func (r *capturedRequest) Host() string {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// Duration is the time since the request was sent, till the end if done
+func (r *capturedRequest) Duration() time.Duration {
+	if r.End.IsZero() {
+		return 0
+	}
+	return r.End.Sub(r.Start)
+}
+
+// capture keeps up to max most recent requests
+type capture struct {
+	max  int
+	m    map[string]*capturedRequest
+	list []*capturedRequest
+}
+
+func newCapture(max int) *capture {
+	return &capture{max: max, m: make(map[string]*capturedRequest)}
+}
+
+func (c *capture) Reset() {
+	c.m = make(map[string]*capturedRequest)
+	c.list = nil
+}
+
+// networkEvent has the fields of the Network events the capture uses,
+// decoded leniently as network.Event* fail on the enum values they don't know
+type networkEvent struct {
+	RequestID string             `json:"requestId"`
+	Timestamp *cdp.MonotonicTime `json:"timestamp"`
+	Request   *struct {
+		Method   string          `json:"method"`
+		URL      string          `json:"url"`
+		Headers  network.Headers `json:"headers"`
+		PostData string          `json:"postData"`
+	} `json:"request"`
+	Response *struct {
+		Status     int64           `json:"status"`
+		StatusText string          `json:"statusText"`
+		Protocol   string          `json:"protocol"`
+		Headers    network.Headers `json:"headers"`
+	} `json:"response"`
+	DataLength int64  `json:"dataLength"`
+	ErrorText  string `json:"errorText"`
+}
+
+// apply updates the capture with the event, returning the updated request if any
+func (c *capture) apply(e cdpMessage) (*capturedRequest, error) {
+	if !strings.HasPrefix(e.Method, "Network.") {
+		return nil, nil
+	}
+
+	var p networkEvent
+	if err := json.Unmarshal(e.Params, &p); err != nil {
+		return nil, err
+	}
+
+	if e.Method == "Network.requestWillBeSent" {
+		r := &capturedRequest{ID: p.RequestID, Start: monotonic(p.Timestamp)}
+		if p.Request != nil {
+			r.Method, r.URL, r.RequestHeaders, r.PostData = p.Request.Method, p.Request.URL, p.Request.Headers, p.Request.PostData
+		}
+		c.add(r)
+		return r, nil
+	}
+
+	r, ok := c.m[p.RequestID]
+	if !ok {
+		return nil, nil
+	}
+	switch e.Method {
+	case "Network.responseReceived":
+		if p.Response == nil {
+			return nil, nil
+		}
+		r.Status, r.StatusText, r.Protocol, r.ResponseHeaders = p.Response.Status, p.Response.StatusText, p.Response.Protocol, p.Response.Headers
+	case "Network.dataReceived":
+		r.Size += p.DataLength
+	case "Network.loadingFinished":
+		r.Done, r.End = true, monotonic(p.Timestamp)
+	case "Network.loadingFailed":
+		r.Done, r.Failed, r.ErrorText, r.End = true, true, p.ErrorText, monotonic(p.Timestamp)
+	default:
+		return nil, nil
+	}
+	return r, nil
+}
+
+func (c *capture) add(r *capturedRequest) {
+	if old, ok := c.m[r.ID]; ok {
+		// a redirect reuses the request ID
+		*old = *r
+		return
+	}
+	c.m[r.ID] = r
+	c.list = append(c.list, r)
+	if c.max > 0 && len(c.list) > c.max {
+		delete(c.m, c.list[0].ID)
+		c.list[0] = nil
+		c.list = c.list[1:]
+	}
+}
+
+func (c *capture) remove(r *capturedRequest) {
+	delete(c.m, r.ID)
+	for i := range c.list {
+		if c.list[i] == r {
+			c.list = append(c.list[:i], c.list[i+1:]...)
+			break
+		}
+	}
+}
+
+func monotonic(t *cdp.MonotonicTime) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.Time()
+}
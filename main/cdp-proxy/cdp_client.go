@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// cdpClient is a minimal client of the `/cdp` endpoint, used by the subcommands
+type cdpClient struct {
+	URL string
+
+	conn   *websocket.Conn
+	wmu    sync.Mutex
+	lastID int64
+
+	mu      sync.Mutex
+	pending map[int64]chan cdpMessage
+	err     error
+
+	events chan cdpMessage
+	done   chan struct{}
+
+	// queue buffers the events for the events channel, so that the read loop never waits for them
+	// and the command responses get through meanwhile
+	qmu    sync.Mutex
+	qcond  *sync.Cond
+	queue  []cdpMessage
+	qdone  bool
+	closed chan struct{}
+	once   sync.Once
+}
+
+// maxQueuedEvents is how far the events' reader can fall behind before the connection is closed
+const maxQueuedEvents = 100000
+
+// cdpMessage is either a command response or an event
+type cdpMessage struct {
+	ID     int64           `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *cdpError       `json:"error,omitempty"`
+}
+
+type cdpError struct {
+	Code    int64  `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *cdpError) Error() string {
+	return fmt.Sprintf("cdp: code=%d message=%q", e.Code, e.Message)
+}
+
+// cdpURL is the websocket URL of the session at addr, which is either host:port or the URL itself
+func cdpURL(addr, session string) string {
+	if strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://") {
+		return addr
+	}
+	u := "ws://" + addr + "/cdp"
+	if session != "" {
+		u += "/" + url.PathEscape(session)
+	}
+	return u
+}
+
+// dialCDP connects to the session, skipping the wss cert verification if insecure
+func dialCDP(ctx context.Context, addr, session, token string, insecure bool) (*cdpClient, error) {
+	var (
+		u = cdpURL(addr, session)
+		h http.Header
+		d = *websocket.DefaultDialer
+	)
+	if token != "" {
+		h = http.Header{"Authorization": {"Bearer " + token}}
+	}
+	if insecure {
+		d.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	conn, _, err := d.DialContext(ctx, u, h)
+	if err != nil {
+		return nil, fmt.Errorf("dialCDP: url=%q error=%w", u, err)
+	}
+
+	c := &cdpClient{
+		URL:     u,
+		conn:    conn,
+		pending: make(map[int64]chan cdpMessage),
+		events:  make(chan cdpMessage, 1000),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	c.qcond = sync.NewCond(&c.qmu)
+	go c.readLoop()
+	go c.deliver()
+	return c, nil
+}
+
+func (c *cdpClient) readLoop() {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+
+		c.qmu.Lock()
+		c.qdone = true
+		c.qmu.Unlock()
+		c.qcond.Signal()
+	}()
+
+	for {
+		var m cdpMessage
+		if err = c.conn.ReadJSON(&m); err != nil {
+			return
+		}
+
+		if m.Method != "" {
+			if err = c.enqueue(m); err != nil {
+				c.conn.Close()
+				return
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[m.ID]
+		delete(c.pending, m.ID)
+		c.mu.Unlock()
+		if ok {
+			ch <- m
+		}
+	}
+}
+
+func (c *cdpClient) enqueue(m cdpMessage) error {
+	c.qmu.Lock()
+	defer c.qmu.Unlock()
+	if len(c.queue) >= maxQueuedEvents {
+		return fmt.Errorf("cdpClient: events=%d: reader is too slow", len(c.queue))
+	}
+	c.queue = append(c.queue, m)
+	c.qcond.Signal()
+	return nil
+}
+
+// deliver sends the queued events to the events channel, closing it once the read loop is done
+func (c *cdpClient) deliver() {
+	defer close(c.events)
+	for {
+		c.qmu.Lock()
+		for len(c.queue) == 0 && !c.qdone {
+			c.qcond.Wait()
+		}
+		if len(c.queue) == 0 {
+			c.qmu.Unlock()
+			return
+		}
+		m := c.queue[0]
+		c.queue = c.queue[1:]
+		c.qmu.Unlock()
+
+		select {
+		case c.events <- m:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// Events are closed once the connection is, see Err
+func (c *cdpClient) Events() <-chan cdpMessage {
+	return c.events
+}
+
+// Err is the reason the connection was closed
+func (c *cdpClient) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Call sends the command and decodes its result into the result, if not nil
+func (c *cdpClient) Call(ctx context.Context, method string, params, result interface{}) error {
+	if params == nil {
+		params = struct{}{}
+	}
+
+	var (
+		id = atomic.AddInt64(&c.lastID, 1)
+		ch = make(chan cdpMessage, 1)
+	)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	c.wmu.Lock()
+	err := c.conn.WriteJSON(map[string]interface{}{"id": id, "method": method, "params": params})
+	c.wmu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return fmt.Errorf("%s: connection closed: %v", method, c.Err())
+	case m := <-ch:
+		if m.Error != nil {
+			return fmt.Errorf("%s: %w", method, m.Error)
+		}
+		if result == nil || len(m.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(m.Result, result)
+	}
+}
+
+func (c *cdpClient) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	c.wmu.Lock()
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.wmu.Unlock()
+	return c.conn.Close()
+}
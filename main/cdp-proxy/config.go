@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gmarik/cdp-proxy/cdpproxy"
+)
+
+// envPrefix prefixes the environment variables named after the flags, e.g. CDP_PROXY_HTTP_CDP_ADDR for -http-cdp-addr
+const envPrefix = "CDP_PROXY_"
+
+// configOnly are the flags not read from the config file nor printed
+var configOnly = map[string]bool{"config": true, "print-config": true}
+
+// secretFlags are redacted by -print-config
+var secretFlags = map[string]bool{"proxy-users": true, "cdp-token": true, "cdp-basic-auth": true}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// loadConfig sets the flags not set on the command line from the environment, then from the config file if any,
+// so the precedence is: command line, environment, config file, defaults
+func loadConfig(fs *flag.FlagSet, path string) error {
+	var set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || set[f.Name] || configOnly[f.Name] {
+			return
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid value %q: %v", envName(f.Name), v, err))
+			return
+		}
+		set[f.Name] = true
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("environment: %s", strings.Join(errs, "; "))
+	}
+
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		values map[string]interface{}
+		dec    = json.NewDecoder(bytes.NewReader(data))
+	)
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var keys = make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if fs.Lookup(k) == nil || configOnly[k] {
+			errs = append(errs, fmt.Sprintf("%q: unknown key", k))
+			continue
+		}
+		if set[k] {
+			continue
+		}
+
+		var v string
+		switch x := values[k].(type) {
+		case string:
+			v = x
+		case json.Number:
+			v = x.String()
+		case bool:
+			v = fmt.Sprint(x)
+		case []interface{}:
+			// the lists are comma separated, as on the command line
+			var items []string
+			for _, item := range x {
+				items = append(items, fmt.Sprint(item))
+			}
+			v = strings.Join(items, ",")
+		default:
+			errs = append(errs, fmt.Sprintf("%q: unexpected value %v", k, x))
+			continue
+		}
+		if err := fs.Set(k, v); err != nil {
+			errs = append(errs, fmt.Sprintf("%q: invalid value %q: %v", k, v, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %s", path, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// printConfig writes the effective configuration in the config file format
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	var values = make(map[string]interface{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if configOnly[f.Name] {
+			return
+		}
+		var v interface{} = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			switch x := g.Get().(type) {
+			case bool, int, int64, uint, uint64, float64:
+				v = x
+			case time.Duration:
+				v = x.String()
+			}
+		}
+		if secretFlags[f.Name] && f.Value.String() != "" {
+			v = "REDACTED"
+		}
+		values[f.Name] = v
+	})
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// validateConfig reports all the invalid settings at once
+func validateConfig() error {
+	var errs []string
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			check(name, fmt.Errorf("%v: must be positive", d))
+		}
+	}
+	nonNegative := func(name string, n int64) {
+		if n < 0 {
+			check(name, fmt.Errorf("%d: must not be negative", n))
+		}
+	}
+	pair := func(cert, key, certFile, keyFile string) {
+		if (certFile == "") != (keyFile == "") {
+			check(cert, fmt.Errorf("-%s and -%s are required together", cert, key))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(HTTP_CDP_HostPort)
+	check("http-cdp-addr", err)
+	for _, hostPort := range strings.Split(HTTP_Proxy_HostPort, ",") {
+		_, _, err := net.SplitHostPort(strings.TrimSpace(hostPort))
+		check("http-proxy-addr", err)
+	}
+	if _, ok := sessionKeys[Session_By]; !ok {
+		check("session-by", fmt.Errorf("unknown value %q", Session_By))
+	}
+	check("log-level", new(cdpproxy.Logger).SetLevel(Log_Level))
+
+	nonNegative("jsonl-max-size", JSONL_MaxSize)
+	nonNegative("jsonl-max-age", int64(JSONL_MaxAge))
+	nonNegative("ws-read-buffer-size", int64(WS_ReadBufferSize))
+	nonNegative("ws-write-buffer-size", int64(WS_WriteBufferSize))
+	positive("tunnel-dial-timeout", Tunnel_DialTimeout)
+	nonNegative("body-max-size", int64(Body_MaxSize))
+	nonNegative("bodies-max-size", int64(Bodies_MaxSize))
+	positive("eventbus-timeout", EventBus_Timeout)
+	positive("shutdown-timeout", Shutdown_Timeout)
+
+	if CDP_BasicAuth != "" && !strings.Contains(CDP_BasicAuth, ":") {
+		check("cdp-basic-auth", fmt.Errorf("expected user:password"))
+	}
+	_, err = parseUsers(Proxy_Users)
+	check("proxy-users", err)
+	_, err = cdpproxy.ParseIPNets(Proxy_AllowIPs)
+	check("proxy-allow-ips", err)
+	_, err = cdpproxy.ParseIPNets(CDP_AllowIPs)
+	check("cdp-allow-ips", err)
+	pair("cdp-tls-cert", "cdp-tls-key", CDP_TLSCert, CDP_TLSKey)
+	pair("proxy-tls-cert", "proxy-tls-key", Proxy_TLSCert, Proxy_TLSKey)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -2,62 +2,311 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sys/unix"
 
-	httpx "github.com/gmarik/cdp-proxy/http"
-	"github.com/gmarik/cdp-proxy/main/cdp-proxy/httpcdp"
+	"github.com/gmarik/cdp-proxy/cdpproxy"
+	"github.com/gmarik/cdp-proxy/metrics"
+	"github.com/gmarik/cdp-proxy/otlp"
 )
 
 var (
-	HTTP_CDP_HostPort   = "localhost:9229"
-	HTTP_Proxy_HostPort = "localhost:8080"
+	HTTP_CDP_HostPort         = "localhost:9229"
+	HTTP_Proxy_HostPort       = "localhost:8080"
+	Rules_Path                = ""
+	Session_By                = "none"
+	JSONL_Path                = ""
+	JSONL_MaxSize       int64 = 100 << 20
+	JSONL_MaxAge              = 24 * time.Hour
+	JSONL_Bodies              = false
+	OTLP_Endpoint             = ""
+	OTLP_Inject               = false
+	OTLP_ServiceName          = "cdp-proxy"
+	Proxy_Users               = ""
+	Proxy_AllowIPs            = ""
+	CDP_Token                 = ""
+	CDP_BasicAuth             = ""
+	CDP_Origins               = ""
+	CDP_AllowIPs              = ""
+	CDP_TLS                   = false
+	CDP_TLSCert               = ""
+	CDP_TLSKey                = ""
+	CDP_TLSClientCA           = ""
+	Proxy_TLS                 = false
+	Proxy_TLSCert             = ""
+	Proxy_TLSKey              = ""
+	Proxy_TLSClientCA         = ""
+	Shutdown_Timeout          = 10 * time.Second
+	Tunnel_DialTimeout        = 5 * time.Second
+	EventBus_Timeout          = cdpproxy.DefaultReaderTimeout
+	Body_MaxSize              = cdpproxy.DefaultMaxBodySize
+	Bodies_MaxSize            = cdpproxy.DefaultMaxBodiesSize
+	WS_ReadBufferSize         = 10 << 20
+	WS_WriteBufferSize        = 25 << 20
+	CDP_Verbose               = ""
+	Log_Level                 = "debug"
+	Config_Path               = ""
+	Print_Config              = false
 )
 
+var sessionKeys = map[string]func(*http.Request) string{
+	"none": nil,
+	"port": cdpproxy.SessionByPort,
+	"ip":   cdpproxy.SessionByIP,
+	"user": cdpproxy.SessionByUser,
+}
+
+// subcommands are the clients of a running proxy, `cdp-proxy <subcommand> -h` for the usage
+var subcommands = map[string]func(args []string) error{
+	"tail": runTail,
+	"tui":  runTUI,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("%s: error=%q", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	flag.StringVar(&HTTP_CDP_HostPort, "http-cdp-addr", HTTP_CDP_HostPort, "Chrome Devtools Protocol(CDP) listener address(host:port)")
-	flag.StringVar(&HTTP_Proxy_HostPort, "http-proxy-addr", HTTP_Proxy_HostPort, "HTTP proxy listener address(host:port)")
+	flag.StringVar(&HTTP_Proxy_HostPort, "http-proxy-addr", HTTP_Proxy_HostPort, "HTTP proxy listener address(host:port), comma separated for multiple listeners")
+	flag.StringVar(&Rules_Path, "rules", Rules_Path, "rules file path, with a \"block <host-glob>\" or \"throttle <profile>\" directive per line")
+	flag.StringVar(&Session_By, "session-by", Session_By, "split the traffic into DevTools sessions by: none, port, ip, user")
+	flag.StringVar(&JSONL_Path, "jsonl", JSONL_Path, "traffic log path to write a JSON object per completed request to")
+	flag.Int64Var(&JSONL_MaxSize, "jsonl-max-size", JSONL_MaxSize, "traffic log size in bytes to rotate it at, 0 disables")
+	flag.DurationVar(&JSONL_MaxAge, "jsonl-max-age", JSONL_MaxAge, "traffic log age to rotate it at, 0 disables")
+	flag.BoolVar(&JSONL_Bodies, "jsonl-bodies", JSONL_Bodies, "include the base64 encoded response bodies into the traffic log")
+	flag.StringVar(&OTLP_Endpoint, "otlp-endpoint", OTLP_Endpoint, "OTLP/HTTP traces URL to export the spans of the proxied requests to, e.g. http://localhost:4318/v1/traces")
+	flag.BoolVar(&OTLP_Inject, "otlp-inject", OTLP_Inject, "propagate the spans upstream with the traceparent header")
+	flag.StringVar(&OTLP_ServiceName, "otlp-service-name", OTLP_ServiceName, "service.name of the exported spans")
+	flag.StringVar(&Proxy_Users, "proxy-users", Proxy_Users, "comma separated user:password pairs required as the proxy's Proxy-Authorization Basic credentials")
+	flag.StringVar(&Proxy_AllowIPs, "proxy-allow-ips", Proxy_AllowIPs, "comma separated IPs and CIDRs of the proxy clients, all if empty")
+	flag.StringVar(&CDP_Token, "cdp-token", CDP_Token, "token required by the CDP server as `Authorization: Bearer <token>` or the `token` query param")
+	flag.StringVar(&CDP_BasicAuth, "cdp-basic-auth", CDP_BasicAuth, "user:password required by the CDP server as the basic auth credentials")
+	flag.StringVar(&CDP_Origins, "cdp-origins", CDP_Origins, "comma separated websocket origins allowed in addition to DevTools and the CDP server itself, * for all")
+	flag.StringVar(&CDP_AllowIPs, "cdp-allow-ips", CDP_AllowIPs, "comma separated IPs and CIDRs of the CDP clients, all if empty")
+	flag.BoolVar(&CDP_TLS, "cdp-tls", CDP_TLS, "serve the CDP server over TLS(https, wss), with a self-signed cert unless -cdp-tls-cert")
+	flag.StringVar(&CDP_TLSCert, "cdp-tls-cert", CDP_TLSCert, "CDP server TLS cert file, implies -cdp-tls")
+	flag.StringVar(&CDP_TLSKey, "cdp-tls-key", CDP_TLSKey, "CDP server TLS key file")
+	flag.StringVar(&CDP_TLSClientCA, "cdp-tls-client-ca", CDP_TLSClientCA, "CA file to verify the CDP clients' certs with(mTLS), implies -cdp-tls")
+	flag.BoolVar(&Proxy_TLS, "proxy-tls", Proxy_TLS, "serve the proxy over TLS(an HTTPS proxy), with a self-signed cert unless -proxy-tls-cert")
+	flag.StringVar(&Proxy_TLSCert, "proxy-tls-cert", Proxy_TLSCert, "proxy TLS cert file, implies -proxy-tls")
+	flag.StringVar(&Proxy_TLSKey, "proxy-tls-key", Proxy_TLSKey, "proxy TLS key file")
+	flag.StringVar(&Proxy_TLSClientCA, "proxy-tls-client-ca", Proxy_TLSClientCA, "CA file to verify the proxy clients' certs with(mTLS), implies -proxy-tls")
+	flag.DurationVar(&Shutdown_Timeout, "shutdown-timeout", Shutdown_Timeout, "time to drain the in-flight requests and tunnels, and then the CDP connections, on SIGINT/SIGTERM")
+	flag.DurationVar(&Tunnel_DialTimeout, "tunnel-dial-timeout", Tunnel_DialTimeout, "CONNECT tunnels' upstream dial timeout")
+	flag.DurationVar(&EventBus_Timeout, "eventbus-timeout", EventBus_Timeout, "time to wait for a slow CDP client before dropping it")
+	flag.IntVar(&Body_MaxSize, "body-max-size", Body_MaxSize, "response body size in bytes to keep for DevTools at most, the larger ones aren't kept")
+	flag.IntVar(&Bodies_MaxSize, "bodies-max-size", Bodies_MaxSize, "response bodies size in bytes to keep per session at most, the oldest ones are dropped")
+	flag.IntVar(&WS_ReadBufferSize, "ws-read-buffer-size", WS_ReadBufferSize, "CDP websockets' read buffer size in bytes")
+	flag.IntVar(&WS_WriteBufferSize, "ws-write-buffer-size", WS_WriteBufferSize, "CDP websockets' write buffer size in bytes")
+	flag.StringVar(&CDP_Verbose, "cdp-verbose", CDP_Verbose, "comma separated CDP server path prefixes to log the requests of, e.g. json,cdp")
+	flag.StringVar(&Log_Level, "log-level", Log_Level, "minimum level logged: debug, info, warn, error")
+	flag.StringVar(&Config_Path, "config", os.Getenv(envName("config")), "JSON config file with the flag names as the keys, overridden by the "+envPrefix+"<FLAG_NAME> environment variables and the flags")
+	flag.BoolVar(&Print_Config, "print-config", Print_Config, "print the effective configuration and exit")
 	flag.Parse()
 
+	if err := loadConfig(flag.CommandLine, Config_Path); err != nil {
+		log.Fatalf("config: error=%q", err)
+	}
+	if err := validateConfig(); err != nil {
+		log.Fatalf("config: invalid: error=%q", err)
+	}
+	if Print_Config {
+		if err := printConfig(os.Stdout, flag.CommandLine); err != nil {
+			log.Fatalf("config: error=%q", err)
+		}
+		return
+	}
+
+	var sessionKey = sessionKeys[Session_By]
+	metrics.Default.Register(cdpproxy.Metrics)
+
 	var (
-		eb             = httpcdp.NewEventBus()
+		sessions       = cdpproxy.NewSessions(sessionKey)
+		logger         = cdpproxy.NewLogger(sessions)
 		ctx, cancel_Fn = context.WithCancel(context.Background())
 	)
 	defer cancel_Fn()
+	sessions.ReaderTimeout = EventBus_Timeout
+	sessions.MaxBodySize, sessions.MaxBodiesSize = Body_MaxSize, Bodies_MaxSize
+	logger.SetLevel(Log_Level)
+
+	if JSONL_Path != "" {
+		tl, err := cdpproxy.NewTrafficLog(JSONL_Path, JSONL_MaxSize, JSONL_MaxAge)
+		if err != nil {
+			log.Fatalf("jsonl: error=%q", err)
+		}
+		defer tl.Close()
+		tl.Bodies = JSONL_Bodies
+		sessions.Subscribe(tl.Attach)
+	}
+
+	proxyUsers, err := parseUsers(Proxy_Users)
+	if err != nil {
+		log.Fatalf("proxy-users: error=%q", err)
+	}
+	proxyIPs, err := cdpproxy.ParseIPNets(Proxy_AllowIPs)
+	if err != nil {
+		log.Fatalf("proxy-allow-ips: error=%q", err)
+	}
+	cdpIPs, err := cdpproxy.ParseIPNets(CDP_AllowIPs)
+	if err != nil {
+		log.Fatalf("cdp-allow-ips: error=%q", err)
+	}
+
+	var (
+		proxyHostPorts = strings.Split(HTTP_Proxy_HostPort, ",")
+		cdpTLS         *tls.Config
+		proxyTLS       *tls.Config
+	)
+	if CDP_TLS || CDP_TLSCert != "" || CDP_TLSClientCA != "" {
+		if cdpTLS, err = tlsConfig(CDP_TLSCert, CDP_TLSKey, CDP_TLSClientCA, []string{HTTP_CDP_HostPort}); err != nil {
+			log.Fatalf("cdp-tls: error=%q", err)
+		}
+	}
+	if Proxy_TLS || Proxy_TLSCert != "" || Proxy_TLSClientCA != "" {
+		if proxyTLS, err = tlsConfig(Proxy_TLSCert, Proxy_TLSKey, Proxy_TLSClientCA, proxyHostPorts); err != nil {
+			log.Fatalf("proxy-tls: error=%q", err)
+		}
+	}
+
+	var exp *otlp.Exporter
+	if OTLP_Endpoint != "" {
+		exp = otlp.NewExporter(OTLP_Endpoint, OTLP_ServiceName)
+		exp.Logger = logger
+		defer func() {
+			ctx, cancel_Fn := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel_Fn()
+			if err := exp.Shutdown(ctx); err != nil {
+				log.Printf("otlp: shutdown: error=%q", err)
+			}
+		}()
+	}
+
+	// always listed so there's a target to connect to
+	sessions.Get(cdpproxy.DefaultSession)
 
+	var rs = &rules{path: Rules_Path, throttle: "none"}
+	if Rules_Path != "" {
+		if err := rs.Reload(); err != nil {
+			log.Fatalf("rules: error=%q", err)
+		}
+	}
+
+	var (
+		listening int32
+		cdpDone   = make(chan struct{})
+	)
 	go func() {
 		var (
 			px = "devtools: http.ListenAndServe:"
-			s  = httpcdp.Server{
-				Eventbus: eb,
-				HostPort: HTTP_CDP_HostPort,
+			s  = cdpproxy.Server{
+				Sessions:        sessions,
+				HostPort:        HTTP_CDP_HostPort,
+				Logger:          logger,
+				Commands:        rs.Commands(),
+				Token:           CDP_Token,
+				BasicAuth:       CDP_BasicAuth,
+				AllowedOrigins:  splitList(CDP_Origins),
+				AllowedIPs:      cdpIPs,
+				TLSConfig:       cdpTLS,
+				Verbose:         CDP_Verbose,
+				ReadBufferSize:  WS_ReadBufferSize,
+				WriteBufferSize: WS_WriteBufferSize,
+				ShutdownTimeout: Shutdown_Timeout,
+				Ready: func() error {
+					if n := atomic.LoadInt32(&listening); int(n) < len(proxyHostPorts) {
+						return fmt.Errorf("proxy: listening=%d/%d", n, len(proxyHostPorts))
+					}
+					return nil
+				},
 			}
 		)
+		defer close(cdpDone)
 		defer log.Printf("%s done", px)
 		log.Printf("%s address=%q", px, HTTP_CDP_HostPort)
 
 		if err := s.ListenAndServe(ctx); err != nil {
-			log.Fatalf("%s error=%q", px, err)
+			if ctx.Err() == nil {
+				log.Fatalf("%s error=%q", px, err)
+			}
+			log.Printf("%s error=%q", px, err)
 		}
 	}()
 
-	go func() {
-		px := "proxy: http.ListenAndServe:"
-		defer log.Printf("%s done", px)
-		log.Printf("%s address=%q", px, HTTP_Proxy_HostPort)
-		if err := http.ListenAndServe(HTTP_Proxy_HostPort, httpx.Handler(eb, proxy)); err != nil {
-			log.Fatalf("%s error=%q", px, err)
+	var (
+		proxy = &cdpproxy.Proxy{
+			Sessions:          sessions,
+			Logger:            logger,
+			Middleware:        rs.Handler,
+			DialTimeout:       Tunnel_DialTimeout,
+			Exporter:          exp,
+			InjectTraceparent: OTLP_Inject,
+			ShutdownTimeout:   Shutdown_Timeout,
+			Auth: func(next http.Handler) http.Handler {
+				return proxyAuth(proxyUsers, proxyIPs, logger, next)
+			},
 		}
-	}()
+		proxyCtx, proxyCancel_Fn = context.WithCancel(context.Background())
+		proxiesDone              sync.WaitGroup
+	)
+	defer proxyCancel_Fn()
+	for _, hostPort := range proxyHostPorts {
+		proxiesDone.Add(1)
+		go func(hostPort string) {
+			defer proxiesDone.Done()
+			px := "proxy: http.Serve:"
+			defer log.Printf("%s done", px)
+			log.Printf("%s address=%q", px, hostPort)
 
-	sigc := make(chan os.Signal)
+			ln, err := net.Listen("tcp", hostPort)
+			if err != nil {
+				log.Fatalf("%s error=%q", px, err)
+			}
+			if proxyTLS != nil {
+				ln = tls.NewListener(ln, proxyTLS)
+			}
+			atomic.AddInt32(&listening, 1)
+			if err := proxy.Serve(proxyCtx, ln); err != nil {
+				if proxyCtx.Err() == nil {
+					log.Fatalf("%s error=%q", px, err)
+				}
+				log.Printf("proxy: shutdown: error=%q", err)
+			}
+		}(strings.TrimSpace(hostPort))
+	}
+
+	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, unix.SIGTERM, unix.SIGINT)
 	defer signal.Stop(sigc)
 
-	log.Printf("os: signal=%v", <-sigc)
+	log.Printf("os: signal=%v timeout=%v, shutting down", <-sigc, Shutdown_Timeout)
+	go func() {
+		log.Printf("os: signal=%v, exiting", <-sigc)
+		os.Exit(1)
+	}()
+
+	// the proxies drain the in-flight requests and tunnels, closing the remaining ones after the timeout
+	proxyCancel_Fn()
+	proxiesDone.Wait()
+	// the CDP connections last till the proxied requests are done, to receive their events
+	cancel_Fn()
+	<-cdpDone
 }
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gmarik/cdp-proxy/cdpproxy"
+)
+
+// https://source.chromium.org/chromium/chromium/src/+/master:third_party/devtools-frontend/src/front_end/sdk/NetworkManager.js
+var throttleProfiles = map[string]throttleProfile{
+	"none":    {},
+	"offline": {offline: true},
+	"3g":      {latency: 562 * time.Millisecond, bytesPerSec: 1440 * 1024 / 8},
+	"slow-3g": {latency: 2000 * time.Millisecond, bytesPerSec: 400 * 1024 / 8},
+}
+
+type throttleProfile struct {
+	offline     bool
+	latency     time.Duration
+	bytesPerSec int
+}
+
+// rules block and throttle the proxied requests.
+// The rules file has a directive per line: `block <host-glob>` or `throttle <profile>`.
+type rules struct {
+	path string
+
+	mu       sync.RWMutex
+	blocks   []string
+	throttle string
+}
+
+func (rs *rules) Block(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("block: pattern=%q error=%v", pattern, err)
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, p := range rs.blocks {
+		if p == pattern {
+			return nil
+		}
+	}
+	rs.blocks = append(rs.blocks, pattern)
+	return nil
+}
+
+func (rs *rules) Unblock(pattern string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i, p := range rs.blocks {
+		if p == pattern {
+			rs.blocks = append(rs.blocks[:i], rs.blocks[i+1:]...)
+			return
+		}
+	}
+}
+
+func (rs *rules) Throttle(profile string) error {
+	if _, ok := throttleProfiles[profile]; !ok {
+		return fmt.Errorf("throttle: unknown profile %q", profile)
+	}
+	rs.mu.Lock()
+	rs.throttle = profile
+	rs.mu.Unlock()
+	return nil
+}
+
+// Reload replaces the rules with the ones from the rules file
+func (rs *rules) Reload() error {
+	if rs.path == "" {
+		return fmt.Errorf("rules: no rules file, see -rules flag")
+	}
+
+	f, err := os.Open(rs.path)
+	if err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		loaded = rules{throttle: "none"}
+		sc     = bufio.NewScanner(f)
+	)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var fields = strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("rules: %s:%d: invalid directive %q", rs.path, n, line)
+		}
+		switch fields[0] {
+		case "block":
+			err = loaded.Block(fields[1])
+		case "throttle":
+			err = loaded.Throttle(fields[1])
+		default:
+			err = fmt.Errorf("unknown directive %q", fields[0])
+		}
+		if err != nil {
+			return fmt.Errorf("rules: %s:%d: %w", rs.path, n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+
+	rs.mu.Lock()
+	rs.blocks, rs.throttle = loaded.blocks, loaded.throttle
+	rs.mu.Unlock()
+	return nil
+}
+
+func (rs *rules) blocked(host string) bool {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	for _, p := range rs.blocks {
+		if ok, _ := path.Match(p, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *rules) profile() throttleProfile {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return throttleProfiles[rs.throttle]
+}
+
+func (rs *rules) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if rs.blocked(strings.ToLower(host)) {
+			http.Error(w, "blocked by cdp-proxy", http.StatusForbidden)
+			return
+		}
+
+		p := rs.profile()
+		if p.offline {
+			http.Error(w, "offline by cdp-proxy", http.StatusServiceUnavailable)
+			return
+		}
+		if p.latency > 0 {
+			select {
+			case <-time.After(p.latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		// NOTE: tunnels get the latency only
+		if p.bytesPerSec > 0 && r.Method != http.MethodConnect {
+			w = &throttledWriter{ResponseWriter: w, bytesPerSec: p.bytesPerSec}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rs *rules) Commands() map[string]cdpproxy.Command {
+	return map[string]cdpproxy.Command{
+		"block": {
+			Usage: `block("*.ads.com") responds 403 to the requests to matching hosts`,
+			Run: func(args []interface{}) (interface{}, error) {
+				pattern, err := stringArg(args)
+				if err != nil {
+					return nil, err
+				}
+				if err := rs.Block(pattern); err != nil {
+					return nil, err
+				}
+				return rs.list(), nil
+			},
+		},
+		"unblock": {
+			Usage: `unblock("*.ads.com") removes the block`,
+			Run: func(args []interface{}) (interface{}, error) {
+				pattern, err := stringArg(args)
+				if err != nil {
+					return nil, err
+				}
+				rs.Unblock(pattern)
+				return rs.list(), nil
+			},
+		},
+		"throttle": {
+			Usage: `throttle("3g") emulates network conditions: "none", "offline", "3g", "slow-3g"`,
+			Run: func(args []interface{}) (interface{}, error) {
+				profile, err := stringArg(args)
+				if err != nil {
+					return nil, err
+				}
+				if err := rs.Throttle(profile); err != nil {
+					return nil, err
+				}
+				return rs.list(), nil
+			},
+		},
+		"rules": {
+			Usage: "rules() lists the current rules",
+			Pure:  true,
+			Run: func(args []interface{}) (interface{}, error) {
+				return rs.list(), nil
+			},
+		},
+		"rules.reload": {
+			Usage: "rules.reload() reloads the rules file",
+			Run: func(args []interface{}) (interface{}, error) {
+				if err := rs.Reload(); err != nil {
+					return nil, err
+				}
+				return rs.list(), nil
+			},
+		},
+	}
+}
+
+func (rs *rules) list() map[string]interface{} {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return map[string]interface{}{
+		"file":     rs.path,
+		"block":    append([]string{}, rs.blocks...),
+		"throttle": rs.throttle,
+	}
+}
+
+func stringArg(args []interface{}) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("expected 1 argument, got %d", len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return "", fmt.Errorf("expected string argument, got %T", args[0])
+	}
+	return s, nil
+}
+
+// throttledWriter throttles the response writes, the hijacked connections, e.g. the websockets, aren't throttled
+type throttledWriter struct {
+	http.ResponseWriter
+	bytesPerSec int
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	// write in 100ms worth of data chunks
+	var chunk = w.bytesPerSec / 10
+	var written int
+	for len(p) > 0 {
+		n := chunk
+		if n > len(p) {
+			n = len(p)
+		}
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(w.bytesPerSec))
+		nn, err := w.ResponseWriter.Write(p[:n])
+		written += nn
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+		return
+	}
+	panic("http.Flusher: unavailable")
+}
+
+func (w *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("http.Hijacker: unavailable")
+}
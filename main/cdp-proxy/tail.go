@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/gorilla/websocket"
+	"golang.org/x/sys/unix"
+)
+
+// runTail is the `tail` subcommand, printing the finished requests of a running proxy
+func runTail(args []string) error {
+	var (
+		fs          = flag.NewFlagSet("tail", flag.ExitOnError)
+		addr        = fs.String("addr", HTTP_CDP_HostPort, "CDP server address(host:port) or its websocket URL")
+		session     = fs.String("session", "", "session to attach to, the default one if empty")
+		token       = fs.String("token", "", "CDP server token, see -cdp-token")
+		insecure    = fs.Bool("insecure", false, "skip the CDP server cert verification, e.g. of the self-signed one")
+		format      = fs.String("format", "line", "output format: line, curl, json")
+		hosts       = fs.String("host", "", "comma separated host globs to print the requests of")
+		methods     = fs.String("method", "", "comma separated methods to print the requests of")
+		statuses    = fs.String("status", "", "comma separated statuses to print the requests of, e.g. 404,5xx,300-399")
+		minDuration = fs.Duration("min-duration", 0, "print only the requests taking at least the duration")
+		bodies      = fs.Bool("body", false, "print the response bodies too")
+	)
+	fs.Parse(args)
+
+	tf, err := newTailFilter(*hosts, *methods, *statuses, *minDuration)
+	if err != nil {
+		return err
+	}
+	printFn, ok := tailFormats[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	ctx, cancel_Fn := context.WithCancel(context.Background())
+	defer cancel_Fn()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, unix.SIGTERM, unix.SIGINT)
+	defer signal.Stop(sigc)
+
+	c, err := dialCDP(ctx, *addr, *session, *token, *insecure)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Call(ctx, "Network.enable", nil, nil); err != nil {
+		return err
+	}
+
+	var cap = newCapture(0)
+	for {
+		select {
+		case <-sigc:
+			return nil
+		case e, ok := <-c.Events():
+			if !ok {
+				if err := c.Err(); !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return err
+				}
+				return nil
+			}
+
+			r, err := cap.apply(e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "tail: %s: error=%q\n", e.Method, err)
+				continue
+			}
+			if r == nil || !r.Done {
+				continue
+			}
+			// done requests aren't updated anymore
+			cap.remove(r)
+
+			if !tf.match(r) {
+				continue
+			}
+			var body *tailBody
+			if *bodies && !r.Failed {
+				body = fetchBody(ctx, c, r.ID)
+			}
+			if err := printFn(os.Stdout, r, body); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type tailBody struct {
+	data []byte
+	err  error
+}
+
+func fetchBody(ctx context.Context, c *cdpClient, reqID string) *tailBody {
+	var res network.GetResponseBodyReturns
+	if err := c.Call(ctx, "Network.getResponseBody", network.GetResponseBody(network.RequestID(reqID)), &res); err != nil {
+		return &tailBody{err: err}
+	}
+	if !res.Base64encoded {
+		return &tailBody{data: []byte(res.Body)}
+	}
+	data, err := base64.StdEncoding.DecodeString(res.Body)
+	return &tailBody{data: data, err: err}
+}
+
+type tailFilter struct {
+	hosts    []string
+	methods  []string
+	statuses [][2]int64
+	min      time.Duration
+}
+
+func newTailFilter(hosts, methods, statuses string, min time.Duration) (*tailFilter, error) {
+	var tf = &tailFilter{min: min}
+
+	for _, h := range splitList(hosts) {
+		if _, err := path.Match(h, ""); err != nil {
+			return nil, fmt.Errorf("host %q: %w", h, err)
+		}
+		tf.hosts = append(tf.hosts, h)
+	}
+	for _, m := range splitList(methods) {
+		tf.methods = append(tf.methods, strings.ToUpper(m))
+	}
+	for _, s := range splitList(statuses) {
+		r, err := parseStatusRange(s)
+		if err != nil {
+			return nil, err
+		}
+		tf.statuses = append(tf.statuses, r)
+	}
+	return tf, nil
+}
+
+// parseStatusRange parses `404`, `4xx` or `400-499`
+func parseStatusRange(s string) ([2]int64, error) {
+	var lo, hi = s, s
+	switch {
+	case len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx"):
+		lo, hi = s[:1]+"00", s[:1]+"99"
+	case strings.Contains(s, "-"):
+		parts := strings.SplitN(s, "-", 2)
+		lo, hi = parts[0], parts[1]
+	}
+
+	l, err := strconv.ParseInt(lo, 10, 64)
+	if err != nil {
+		return [2]int64{}, fmt.Errorf("status %q: %w", s, err)
+	}
+	h, err := strconv.ParseInt(hi, 10, 64)
+	if err != nil {
+		return [2]int64{}, fmt.Errorf("status %q: %w", s, err)
+	}
+	return [2]int64{l, h}, nil
+}
+
+func (tf *tailFilter) match(r *capturedRequest) bool {
+	if r.Duration() < tf.min {
+		return false
+	}
+	if len(tf.methods) > 0 && !contains(tf.methods, r.Method) {
+		return false
+	}
+	if len(tf.hosts) > 0 {
+		var ok bool
+		for _, h := range tf.hosts {
+			if ok, _ = path.Match(h, r.Host()); ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(tf.statuses) > 0 {
+		var ok bool
+		for _, s := range tf.statuses {
+			if ok = s[0] <= r.Status && r.Status <= s[1]; ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+var tailFormats = map[string]func(w io.Writer, r *capturedRequest, body *tailBody) error{
+	"line": printLine,
+	"curl": printCurl,
+	"json": printJSON,
+}
+
+// printLine prints `time status method duration size url`, followed by the body if any
+func printLine(w io.Writer, r *capturedRequest, body *tailBody) error {
+	var status = fmt.Sprint(r.Status)
+	if r.Failed {
+		status = "ERR"
+	}
+	line := fmt.Sprintf("%s %s %s %s %s %s", time.Now().Format("15:04:05.000"), status, r.Method, fmtDuration(r.Duration()), fmtSize(r.Size), r.URL)
+	if r.Failed {
+		line += fmt.Sprintf(" error=%q", r.ErrorText)
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	if body == nil {
+		return nil
+	}
+	if body.err != nil {
+		_, err := fmt.Fprintf(w, "(body: %v)\n", body.err)
+		return err
+	}
+	if !utf8.Valid(body.data) {
+		_, err := fmt.Fprintf(w, "(binary, %d bytes)\n", len(body.data))
+		return err
+	}
+	if _, err := w.Write(body.data); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// printCurl prints the curl command repeating the request, and the body as a comment if any
+func printCurl(w io.Writer, r *capturedRequest, body *tailBody) error {
+	if r.Method == "CONNECT" {
+		_, err := fmt.Fprintf(w, "# CONNECT %s\n", r.URL)
+		return err
+	}
+
+	var args = []string{"curl"}
+	if r.Method != "GET" {
+		args = append(args, "-X", r.Method)
+	}
+
+	var keys = make([]string, 0, len(r.RequestHeaders))
+	for k := range r.RequestHeaders {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.HasPrefix(strings.ToLower(k), "proxy-") {
+			continue
+		}
+		args = append(args, "-H", shellQuote(fmt.Sprintf("%s: %v", k, r.RequestHeaders[k])))
+	}
+	if r.PostData != "" {
+		args = append(args, "--data-raw", shellQuote(r.PostData))
+	}
+	args = append(args, shellQuote(r.URL))
+
+	if _, err := fmt.Fprintln(w, strings.Join(args, " ")); err != nil {
+		return err
+	}
+	if body != nil && body.err == nil && utf8.Valid(body.data) {
+		for _, l := range strings.Split(string(body.data), "\n") {
+			if _, err := fmt.Fprintf(w, "# %s\n", l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+type tailJSON struct {
+	ID              string          `json:"id"`
+	Method          string          `json:"method"`
+	URL             string          `json:"url"`
+	Status          int64           `json:"status"`
+	StatusText      string          `json:"statusText,omitempty"`
+	Protocol        string          `json:"protocol,omitempty"`
+	DurationMs      float64         `json:"durationMs"`
+	Size            int64           `json:"size"`
+	Failed          bool            `json:"failed,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	RequestHeaders  network.Headers `json:"requestHeaders"`
+	ResponseHeaders network.Headers `json:"responseHeaders,omitempty"`
+	PostData        string          `json:"postData,omitempty"`
+	Body            string          `json:"body,omitempty"`
+	Base64Encoded   bool            `json:"base64Encoded,omitempty"`
+	BodyError       string          `json:"bodyError,omitempty"`
+}
+
+// printJSON prints a JSON object per line
+func printJSON(w io.Writer, r *capturedRequest, body *tailBody) error {
+	var v = tailJSON{
+		ID:              r.ID,
+		Method:          r.Method,
+		URL:             r.URL,
+		Status:          r.Status,
+		StatusText:      r.StatusText,
+		Protocol:        r.Protocol,
+		DurationMs:      float64(r.Duration()) / float64(time.Millisecond),
+		Size:            r.Size,
+		Failed:          r.Failed,
+		Error:           r.ErrorText,
+		RequestHeaders:  r.RequestHeaders,
+		ResponseHeaders: r.ResponseHeaders,
+		PostData:        r.PostData,
+	}
+	switch {
+	case body == nil:
+	case body.err != nil:
+		v.BodyError = body.err.Error()
+	case utf8.Valid(body.data):
+		v.Body = string(body.data)
+	default:
+		v.Body, v.Base64Encoded = base64.StdEncoding.EncodeToString(body.data), true
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
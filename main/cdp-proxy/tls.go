@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// tlsConfig loads the cert and key, or generates a self-signed cert for the listener addresses if empty,
+// and requires the client certs signed by the clientCA, if set
+func tlsConfig(certFile, keyFile, clientCA string, hostPorts []string) (*tls.Config, error) {
+	var (
+		cert tls.Certificate
+		err  error
+	)
+	switch {
+	case certFile != "" && keyFile != "":
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	case certFile == "" && keyFile == "":
+		cert, err = selfSignedCert(hostPorts)
+	default:
+		err = fmt.Errorf("both the cert and the key are required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates", clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// selfSignedCert is valid for the hosts of the addresses, localhost and the loopback IPs
+func selfSignedCert(hostPorts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"cdp-proxy"}, CommonName: "cdp-proxy self-signed"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, hostPort := range hostPorts {
+		host, _, err := net.SplitHostPort(strings.TrimSpace(hostPort))
+		if err != nil || host == "" || host == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if !ip.IsUnspecified() && !ip.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			}
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Printf("tls: self-signed cert: hosts=%v ips=%v sha256=%X", tmpl.DNSNames, tmpl.IPAddresses, sha256.Sum256(der))
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/network"
+	"golang.org/x/sys/unix"
+)
+
+const tuiHelp = "q quit  ↑↓ move  enter details  / filter  f follow  c clear"
+
+// runTUI is the `tui` subcommand, a terminal client of the CDP server for when DevTools isn't available
+func runTUI(args []string) error {
+	var (
+		fs       = flag.NewFlagSet("tui", flag.ExitOnError)
+		addr     = fs.String("addr", HTTP_CDP_HostPort, "CDP server address(host:port) or its websocket URL")
+		session  = fs.String("session", "", "session to attach to, the default one if empty")
+		token    = fs.String("token", "", "CDP server token, see -cdp-token")
+		insecure = fs.Bool("insecure", false, "skip the CDP server cert verification, e.g. of the self-signed one")
+		max      = fs.Int("max", 1000, "number of the most recent requests to keep")
+	)
+	fs.Parse(args)
+
+	ctx, cancel_Fn := context.WithCancel(context.Background())
+	defer cancel_Fn()
+
+	c, err := dialCDP(ctx, *addr, *session, *token, *insecure)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Call(ctx, "Network.enable", nil, nil); err != nil {
+		return err
+	}
+
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return fmt.Errorf("tui: makeRaw: %w", err)
+	}
+	defer restore()
+
+	t := &tui{
+		c:      c,
+		cap:    newCapture(*max),
+		out:    bufio.NewWriter(os.Stdout),
+		follow: true,
+	}
+	return t.run(ctx)
+}
+
+type tuiView int
+
+const (
+	viewList tuiView = iota
+	viewDetail
+)
+
+type tuiBody struct {
+	reqID string
+	text  string
+}
+
+type tui struct {
+	c   *cdpClient
+	cap *capture
+	out *bufio.Writer
+
+	width, height int
+	status        string
+
+	view tuiView
+	// input is the filter or the search being typed, if editing
+	input   *string
+	filter  string
+	visible []*capturedRequest
+	cursor  int
+	top     int
+	follow  bool
+
+	detail *capturedRequest
+	body   *tuiBody
+	lines  []string
+	search string
+	scroll int
+}
+
+func (t *tui) run(ctx context.Context) error {
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+		t.out.Flush()
+	}()
+
+	var (
+		keys   = make(chan string, 16)
+		bodies = make(chan tuiBody, 1)
+		winch  = make(chan os.Signal, 1)
+		events = t.c.Events()
+		tick   = time.NewTicker(50 * time.Millisecond)
+		dirty  = true
+	)
+	defer tick.Stop()
+	go readKeys(os.Stdin, keys)
+	signal.Notify(winch, unix.SIGWINCH)
+	defer signal.Stop(winch)
+
+	t.resize()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				events = nil
+				t.status = fmt.Sprintf("disconnected: %v", t.c.Err())
+			} else if _, err := t.cap.apply(e); err != nil {
+				t.status = fmt.Sprintf("%s: %v", e.Method, err)
+			}
+			dirty = true
+		case k := <-keys:
+			if k == "ctrl-c" || (k == "q" && t.input == nil && t.view == viewList) {
+				return nil
+			}
+			t.key(ctx, k, bodies)
+			t.draw()
+			dirty = false
+		case b := <-bodies:
+			if t.detail != nil && t.detail.ID == b.reqID {
+				t.body = &b
+			}
+			dirty = true
+		case <-winch:
+			t.resize()
+			dirty = true
+		case <-tick.C:
+			if dirty {
+				t.draw()
+				dirty = false
+			}
+		}
+	}
+}
+
+func (t *tui) resize() {
+	w, h, err := termSize(int(os.Stdout.Fd()))
+	if err != nil || w <= 0 || h <= 0 {
+		w, h = 80, 24
+	}
+	t.width, t.height = w, h
+}
+
+// rows is the number of the lines between the title and the status lines
+func (t *tui) rows() int {
+	if t.height < 3 {
+		return 1
+	}
+	return t.height - 2
+}
+
+func (t *tui) key(ctx context.Context, k string, bodies chan<- tuiBody) {
+	if t.input != nil {
+		switch k {
+		case "enter":
+			t.input = nil
+		case "esc":
+			*t.input = ""
+			t.input = nil
+		case "backspace":
+			if s := *t.input; s != "" {
+				_, n := utf8.DecodeLastRuneInString(s)
+				*t.input = s[:len(s)-n]
+			}
+		default:
+			if utf8.RuneCountInString(k) == 1 {
+				*t.input += k
+			}
+		}
+		if t.view == viewDetail {
+			t.scroll = t.nextMatch(0)
+		}
+		return
+	}
+
+	if t.view == viewDetail {
+		t.detailKey(k)
+		return
+	}
+
+	switch k {
+	case "up", "k":
+		t.follow = false
+		t.cursor--
+	case "down", "j":
+		t.cursor++
+	case "pgup":
+		t.follow = false
+		t.cursor -= t.rows()
+	case "pgdn":
+		t.cursor += t.rows()
+	case "home", "g":
+		t.follow = false
+		t.cursor = 0
+	case "end", "G":
+		t.follow = true
+	case "f":
+		t.follow = !t.follow
+	case "c":
+		t.cap.Reset()
+		t.cursor, t.top = 0, 0
+	case "/":
+		t.input = &t.filter
+	case "esc":
+		t.filter = ""
+	case "enter":
+		if t.cursor >= 0 && t.cursor < len(t.visible) {
+			t.open(ctx, t.visible[t.cursor], bodies)
+		}
+	}
+}
+
+func (t *tui) detailKey(k string) {
+	switch k {
+	case "up", "k":
+		t.scroll--
+	case "down", "j":
+		t.scroll++
+	case "pgup":
+		t.scroll -= t.rows()
+	case "pgdn", " ":
+		t.scroll += t.rows()
+	case "home", "g":
+		t.scroll = 0
+	case "end", "G":
+		t.scroll = len(t.lines)
+	case "/":
+		t.search = ""
+		t.input = &t.search
+	case "n":
+		t.scroll = t.nextMatch(t.scroll + 1)
+	case "esc", "q", "backspace":
+		t.view, t.detail, t.body = viewList, nil, nil
+	}
+}
+
+// open shows the request's details fetching its body
+func (t *tui) open(ctx context.Context, r *capturedRequest, bodies chan<- tuiBody) {
+	t.view, t.detail, t.body, t.scroll, t.search = viewDetail, r, nil, 0, ""
+
+	go func(reqID string) {
+		var (
+			res  network.GetResponseBodyReturns
+			text string
+		)
+		if err := t.c.Call(ctx, "Network.getResponseBody", network.GetResponseBody(network.RequestID(reqID)), &res); err != nil {
+			text = fmt.Sprintf("(%v)", err)
+		} else {
+			text = bodyText(res.Body, res.Base64encoded)
+		}
+		select {
+		case bodies <- tuiBody{reqID: reqID, text: text}:
+		case <-ctx.Done():
+		}
+	}(r.ID)
+}
+
+func bodyText(body string, base64Encoded bool) string {
+	var b = []byte(body)
+	if base64Encoded {
+		var err error
+		if b, err = base64.StdEncoding.DecodeString(body); err != nil {
+			return fmt.Sprintf("(base64: %v)", err)
+		}
+	}
+	switch {
+	case len(b) == 0:
+		return "(empty)"
+	case !utf8.Valid(b):
+		return fmt.Sprintf("(binary, %d bytes)", len(b))
+	}
+	return string(b)
+}
+
+// nextMatch is the first detail line from i containing the search, i if none
+func (t *tui) nextMatch(i int) int {
+	if t.search == "" {
+		return i
+	}
+	var s = strings.ToLower(t.search)
+	for j := i; j < len(t.lines); j++ {
+		if strings.Contains(strings.ToLower(t.lines[j]), s) {
+			return j
+		}
+	}
+	return i
+}
+
+func (t *tui) draw() {
+	var lines []string
+	switch t.view {
+	case viewList:
+		lines = t.drawList()
+	case viewDetail:
+		lines = t.drawDetail()
+	}
+
+	fmt.Fprint(t.out, "\x1b[H")
+	for i := 0; i < t.height; i++ {
+		if i < len(lines) {
+			fmt.Fprint(t.out, lines[i])
+		}
+		fmt.Fprint(t.out, "\x1b[K")
+		if i < t.height-1 {
+			fmt.Fprint(t.out, "\r\n")
+		}
+	}
+	t.out.Flush()
+}
+
+func (t *tui) title(s string) string {
+	return "\x1b[7m" + pad(s, t.width) + "\x1b[0m"
+}
+
+// statusLine shows the input being typed, the status message or the help
+func (t *tui) statusLine(help string) string {
+	switch {
+	case t.input != nil:
+		return truncate("/"+*t.input+"█", t.width)
+	case t.status != "":
+		return truncate(t.status, t.width)
+	}
+	return "\x1b[2m" + truncate(help, t.width) + "\x1b[0m"
+}
+
+func (t *tui) drawList() []string {
+	t.visible = t.visible[:0]
+	for _, r := range t.cap.list {
+		if matchFilter(r, t.filter) {
+			t.visible = append(t.visible, r)
+		}
+	}
+
+	var rows = t.rows() - 1
+	if t.follow {
+		t.cursor = len(t.visible) - 1
+	}
+	t.cursor = clamp(t.cursor, 0, len(t.visible)-1)
+	if t.cursor < t.top {
+		t.top = t.cursor
+	}
+	if t.cursor >= t.top+rows {
+		t.top = t.cursor - rows + 1
+	}
+	t.top = clamp(t.top, 0, len(t.visible)-rows)
+
+	var (
+		follow = ""
+		lines  = make([]string, 0, t.height)
+	)
+	if t.follow {
+		follow = " follow"
+	}
+	lines = append(lines,
+		t.title(fmt.Sprintf(" cdp-proxy %s  requests=%d/%d filter=%q%s", t.c.URL, len(t.visible), len(t.cap.list), t.filter, follow)),
+		"\x1b[1m"+truncate(fmt.Sprintf("%-6s %-7s %8s %9s  %s", "STATUS", "METHOD", "TIME", "SIZE", "URL"), t.width)+"\x1b[0m",
+	)
+	for i := t.top; i < len(t.visible) && i < t.top+rows; i++ {
+		line := truncate(listLine(t.visible[i]), t.width)
+		if i == t.cursor {
+			line = "\x1b[7m" + pad(line, t.width) + "\x1b[0m"
+		} else if r := t.visible[i]; r.Failed || r.Status >= 400 {
+			line = "\x1b[31m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < t.height-1 {
+		lines = append(lines, "")
+	}
+	return append(lines, t.statusLine(tuiHelp))
+}
+
+func listLine(r *capturedRequest) string {
+	var status, dur, size = "...", "", ""
+	switch {
+	case r.Failed:
+		status = "ERR"
+	case r.Status != 0:
+		status = fmt.Sprint(r.Status)
+	}
+	if r.Done {
+		dur = fmtDuration(r.Duration())
+		size = fmtSize(r.Size)
+	}
+	return fmt.Sprintf("%-6s %-7s %8s %9s  %s", status, r.Method, dur, size, r.URL)
+}
+
+// matchFilter matches each of the space separated terms against the method, URL and status
+func matchFilter(r *capturedRequest, filter string) bool {
+	var s = strings.ToLower(fmt.Sprintf("%s %s %d", r.Method, r.URL, r.Status))
+	for _, term := range strings.Fields(strings.ToLower(filter)) {
+		if !strings.Contains(s, term) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *tui) drawDetail() []string {
+	t.lines = detailLines(t.detail, t.body)
+
+	var rows = t.rows()
+	t.scroll = clamp(t.scroll, 0, len(t.lines)-rows)
+
+	var (
+		r     = t.detail
+		lines = make([]string, 0, t.height)
+		s     = strings.ToLower(t.search)
+	)
+	lines = append(lines, t.title(fmt.Sprintf(" %s %s  [%d/%d]", r.Method, r.URL, t.scroll+1, len(t.lines))))
+	for i := t.scroll; i < len(t.lines) && i < t.scroll+rows; i++ {
+		line := truncate(t.lines[i], t.width)
+		if s != "" && strings.Contains(strings.ToLower(t.lines[i]), s) {
+			line = "\x1b[43;30m" + line + "\x1b[0m"
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < t.height-1 {
+		lines = append(lines, "")
+	}
+	return append(lines, t.statusLine("esc back  ↑↓ scroll  / search  n next match"))
+}
+
+func detailLines(r *capturedRequest, body *tuiBody) []string {
+	var lines = []string{
+		fmt.Sprintf("Request ID: %s", r.ID),
+		fmt.Sprintf("Status: %d %s %s", r.Status, strings.TrimSpace(strings.TrimPrefix(r.StatusText, fmt.Sprint(r.Status))), r.Protocol),
+	}
+	if r.Done {
+		lines = append(lines, fmt.Sprintf("Time: %s  Size: %s", fmtDuration(r.Duration()), fmtSize(r.Size)))
+	}
+	if r.Failed {
+		lines = append(lines, "Error: "+r.ErrorText)
+	}
+
+	lines = append(lines, "", "\x1b[1mRequest Headers\x1b[0m")
+	lines = append(lines, headerLines(r.RequestHeaders)...)
+	if r.PostData != "" {
+		lines = append(lines, "", "\x1b[1mRequest Body\x1b[0m")
+		lines = append(lines, textLines(r.PostData)...)
+	}
+	lines = append(lines, "", "\x1b[1mResponse Headers\x1b[0m")
+	lines = append(lines, headerLines(r.ResponseHeaders)...)
+
+	lines = append(lines, "", "\x1b[1mResponse Body\x1b[0m")
+	if body == nil {
+		return append(lines, "(loading...)")
+	}
+	return append(lines, textLines(body.text)...)
+}
+
+func headerLines(h network.Headers) []string {
+	var keys = make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var lines = make([]string, 0, len(keys))
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("  %s: %v", k, h[k]))
+	}
+	return lines
+}
+
+// textLines splits the text replacing the control characters which would break the screen
+func textLines(s string) []string {
+	s = strings.NewReplacer("\r\n", "\n", "\t", "    ").Replace(s)
+	var lines = strings.Split(s, "\n")
+	for i, l := range lines {
+		lines[i] = strings.Map(func(r rune) rune {
+			if r < ' ' || r == 0x7f {
+				return '·'
+			}
+			return r
+		}, l)
+	}
+	return lines
+}
+
+// readKeys translates the raw terminal input into key names or the typed characters
+func readKeys(r io.Reader, keys chan<- string) {
+	var seqs = map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+		"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+		"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+	}
+
+	var buf = make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for b := buf[:n]; len(b) > 0; {
+			var k string
+			switch c := b[0]; {
+			case c == 0x1b:
+				k, b = "esc", b[1:]
+				for seq, name := range seqs {
+					if strings.HasPrefix(string(append([]byte{c}, b...)), seq) {
+						k, b = name, b[len(seq)-1:]
+						break
+					}
+				}
+				if k == "esc" && len(b) > 1 && (b[0] == '[' || b[0] == 'O') {
+					// skip the unknown sequence up to its final byte
+					i := 1
+					for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+						i++
+					}
+					if i < len(b) {
+						b = b[i+1:]
+						continue
+					}
+				}
+			case c == 0x03:
+				k, b = "ctrl-c", b[1:]
+			case c == '\r' || c == '\n':
+				k, b = "enter", b[1:]
+			case c == 0x7f || c == 0x08:
+				k, b = "backspace", b[1:]
+			case c < ' ':
+				b = b[1:]
+				continue
+			default:
+				r, size := utf8.DecodeRune(b)
+				k, b = string(r), b[size:]
+			}
+			keys <- k
+		}
+	}
+}
+
+func truncate(s string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	var rs = []rune(s)
+	return string(rs[:width-1]) + "…"
+}
+
+func pad(s string, width int) string {
+	s = truncate(s, width)
+	if n := utf8.RuneCountInString(s); n < width {
+		s += strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+func clamp(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
+
+func fmtDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d/time.Microsecond)
+	case d < 10*time.Second:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+func fmtSize(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%dB", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1fkB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+}
@@ -0,0 +1,422 @@
+// Package metrics implements the counters, gauges and histograms
+// exposed in the Prometheus text format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the registry the New* functions register with
+var Default = &Registry{}
+
+// DefBuckets are the latency buckets in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+	// total is the value summed across the series
+	total() float64
+}
+
+// Registry exposes the registered metrics, and the ones of the registries registered with it
+type Registry struct {
+	mu       sync.Mutex
+	cs       []collector
+	children []*Registry
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cc := range r.cs {
+		if cc.name() == c.name() {
+			panic(fmt.Sprintf("metrics: %q registered twice", c.name()))
+		}
+	}
+	r.cs = append(r.cs, c)
+}
+
+// Register exposes the child registry's metrics with the registry's,
+// e.g. a library's metrics, registered with its own registry, with the Default one.
+func (r *Registry) Register(child *Registry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.children = append(r.children, child)
+}
+
+// collectors are the registry's and its children's collectors
+func (r *Registry) collectors() []collector {
+	r.mu.Lock()
+	var (
+		cs       = append([]collector(nil), r.cs...)
+		children = append([]*Registry(nil), r.children...)
+	)
+	r.mu.Unlock()
+	for _, child := range children {
+		cs = append(cs, child.collectors()...)
+	}
+	return cs
+}
+
+// WriteTo writes the metrics sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var cs = r.collectors()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name() < cs[j].name() })
+
+	var cw = &countingWriter{w: bufio.NewWriter(w)}
+	for _, c := range cs {
+		c.write(cw)
+	}
+	return cw.n, cw.w.Flush()
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// desc is the metric's name, help and labels
+type desc struct {
+	n, help, typ string
+	labels       []string
+}
+
+func (d *desc) name() string { return d.n }
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.n, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help), d.n, d.typ)
+}
+
+// labelPairs formats `{k="v",...}` adding the extra pair if not empty
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var pairs = make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+	}
+	if len(extra) == 2 {
+		pairs = append(pairs, extra[0]+`="`+escapeLabel(extra[1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, +1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// value is a float64 updated atomically
+type value struct {
+	bits uint64
+}
+
+func (v *value) Add(d float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		if atomic.CompareAndSwapUint64(&v.bits, old, math.Float64bits(math.Float64frombits(old)+d)) {
+			return
+		}
+	}
+}
+
+func (v *value) Set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Sum returns the named metric's value summed across its series, the observations count of a histogram,
+// false if it isn't registered.
+func (r *Registry) Sum(name string) (float64, bool) {
+	for _, c := range r.collectors() {
+		if c.name() == name {
+			return c.total(), true
+		}
+	}
+	return 0, false
+}
+
+// vec holds the series by their label values
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]interface{}
+	values map[string][]string
+	newFn  func() interface{}
+}
+
+func newVec(d desc, newFn func() interface{}) *vec {
+	return &vec{desc: d, series: make(map[string]interface{}), values: make(map[string][]string), newFn: newFn}
+}
+
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %q has %d labels, got %d values", v.n, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.newFn()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls fn with the series sorted by their label values
+func (v *vec) each(fn func(values []string, s interface{})) {
+	v.mu.Lock()
+	var keys = make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	var series, values = make(map[string]interface{}, len(keys)), make(map[string][]string, len(keys))
+	for _, k := range keys {
+		series[k], values[k] = v.series[k], v.values[k]
+	}
+	v.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn(values[k], series[k])
+	}
+}
+
+// Counter only goes up
+type Counter struct{ value }
+
+func (c *Counter) Inc() { c.value.Add(1) }
+
+func (c *Counter) Add(d float64) {
+	if d < 0 {
+		panic("metrics: counter can't decrease")
+	}
+	c.value.Add(d)
+}
+
+type CounterVec struct{ *vec }
+
+// NewCounterVec registers the counter partitioned by the labels with the Default registry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec registers the counter partitioned by the labels with the registry.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{newVec(desc{n: name, help: help, typ: "counter", labels: labels}, func() interface{} { return new(Counter) })}
+	r.register(cv)
+	return cv
+}
+
+// NewCounter registers the counter with the Default registry.
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewCounter registers the counter with the registry.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).WithLabelValues()
+}
+
+func (cv *CounterVec) WithLabelValues(values ...string) *Counter {
+	return cv.with(values).(*Counter)
+}
+
+func (cv *CounterVec) total() float64 {
+	var t float64
+	cv.each(func(_ []string, s interface{}) { t += s.(*Counter).Get() })
+	return t
+}
+
+func (cv *CounterVec) write(w io.Writer) {
+	cv.header(w)
+	cv.each(func(values []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.n, cv.labelPairs(values), formatFloat(s.(*Counter).Get()))
+	})
+}
+
+// Gauge goes up and down
+type Gauge struct{ value }
+
+func (g *Gauge) Inc() { g.value.Add(1) }
+func (g *Gauge) Dec() { g.value.Add(-1) }
+
+type GaugeVec struct{ *vec }
+
+// NewGaugeVec registers the gauge partitioned by the labels with the Default registry.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewGaugeVec registers the gauge partitioned by the labels with the registry.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	gv := &GaugeVec{newVec(desc{n: name, help: help, typ: "gauge", labels: labels}, func() interface{} { return new(Gauge) })}
+	r.register(gv)
+	return gv
+}
+
+// NewGauge registers the gauge with the Default registry.
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// NewGauge registers the gauge with the registry.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).WithLabelValues()
+}
+
+func (gv *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return gv.with(values).(*Gauge)
+}
+
+func (gv *GaugeVec) total() float64 {
+	var t float64
+	gv.each(func(_ []string, s interface{}) { t += s.(*Gauge).Get() })
+	return t
+}
+
+func (gv *GaugeVec) write(w io.Writer) {
+	gv.header(w)
+	gv.each(func(values []string, s interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", gv.n, gv.labelPairs(values), formatFloat(s.(*Gauge).Get()))
+	})
+}
+
+// gaugeFunc is a gauge computed at collection time
+type gaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc registers the gauge valued by the fn with the Default registry.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+// NewGaugeFunc registers the gauge valued by the fn with the registry.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{desc: desc{n: name, help: help, typ: "gauge"}, fn: fn})
+}
+
+func (g *gaugeFunc) total() float64 { return g.fn() }
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.n, formatFloat(g.fn()))
+}
+
+// Histogram counts the observations in the buckets
+type Histogram struct {
+	// NOTE: 64-bit atomics need the first words for the alignment on 32-bit platforms
+	count  uint64
+	sum    value
+	upper  []float64
+	counts []uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	h.sum.Add(v)
+	atomic.AddUint64(&h.count, 1)
+}
+
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogramVec registers the histogram partitioned by the labels with the Default registry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogramVec registers the histogram partitioned by the labels with the registry.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	hv := &HistogramVec{buckets: buckets}
+	hv.vec = newVec(desc{n: name, help: help, typ: "histogram", labels: labels}, func() interface{} {
+		return &Histogram{upper: buckets, counts: make([]uint64, len(buckets))}
+	})
+	r.register(hv)
+	return hv
+}
+
+// NewHistogram registers the histogram with the Default registry.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogram(name, help, buckets)
+}
+
+// NewHistogram registers the histogram with the registry.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).WithLabelValues()
+}
+
+func (hv *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return hv.with(values).(*Histogram)
+}
+
+func (hv *HistogramVec) total() float64 {
+	var t uint64
+	hv.each(func(_ []string, s interface{}) { t += atomic.LoadUint64(&s.(*Histogram).count) })
+	return float64(t)
+}
+
+func (hv *HistogramVec) write(w io.Writer) {
+	hv.header(w)
+	hv.each(func(values []string, s interface{}) {
+		var (
+			h          = s.(*Histogram)
+			cumulative uint64
+		)
+		for i, upper := range h.upper {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.n, hv.labelPairs(values, "le", formatFloat(upper)), cumulative)
+		}
+		count := atomic.LoadUint64(&h.count)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.n, hv.labelPairs(values, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.n, hv.labelPairs(values), formatFloat(h.sum.Get()))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.n, hv.labelPairs(values), count)
+	})
+}
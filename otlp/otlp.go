@@ -0,0 +1,442 @@
+// Package otlp exports the spans to an OpenTelemetry collector over OTLP/HTTP with the JSON encoding.
+// https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/protocol/otlp.md
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span, propagated with the W3C `traceparent` header
+// https://www.w3.org/TR/trace-context/#traceparent-header
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+const flagSampled = 0x01
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// Traceparent formats the context as the `traceparent` header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses the `traceparent` header value
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	// version-traceid-parentid-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || (len(s) > 55 && s[55] != '-') {
+		return sc, false
+	}
+	if s[:2] == "ff" || (s[:2] == "00" && len(s) != 55) {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(s[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(s[36:52])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return sc, false
+	}
+	sc.Flags = flags[0]
+	return sc, sc.IsValid()
+}
+
+type SpanKind int
+
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Span is recorded till End, a nil Span is a no-op
+type Span struct {
+	exp    *Exporter
+	sc     SpanContext
+	parent SpanContext
+	name   string
+	kind   SpanKind
+	start  time.Time
+
+	mu     sync.Mutex
+	attrs  []keyValue
+	events []event
+	err    string
+	ended  bool
+}
+
+type event struct {
+	name  string
+	t     time.Time
+	attrs []keyValue
+}
+
+// Context is the span's context, to be propagated to the children
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttr sets the string, bool, int or float attribute
+func (s *Span) SetAttr(key string, v interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, keyValue{key, v})
+	s.mu.Unlock()
+}
+
+// AddEvent records the event with the key, value attribute pairs
+func (s *Span) AddEvent(name string, t time.Time, kvs ...interface{}) {
+	if s == nil {
+		return
+	}
+	var attrs []keyValue
+	for i := 0; i+1 < len(kvs); i += 2 {
+		attrs = append(attrs, keyValue{fmt.Sprint(kvs[i]), kvs[i+1]})
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event{name: name, t: t, attrs: attrs})
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = msg
+	s.mu.Unlock()
+}
+
+// End queues the span for the export, once
+func (s *Span) End(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	sp := s.encode(t)
+	s.mu.Unlock()
+
+	if s.sc.IsSampled() {
+		s.exp.queue(sp)
+	}
+}
+
+func (s *Span) encode(end time.Time) span {
+	sp := span{
+		TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: unixNano(s.start),
+		EndTimeUnixNano:   unixNano(end),
+		Attributes:        encodeAttrs(s.attrs),
+		Events:            make([]spanEvent, 0, len(s.events)),
+	}
+	if s.parent.IsValid() {
+		sp.ParentSpanID = hex.EncodeToString(s.parent.SpanID[:])
+	}
+	for _, e := range s.events {
+		sp.Events = append(sp.Events, spanEvent{TimeUnixNano: unixNano(e.t), Name: e.name, Attributes: encodeAttrs(e.attrs)})
+	}
+	if s.err != "" {
+		sp.Status = &status{Code: 2, Message: s.err}
+	}
+	return sp
+}
+
+// Logger logs the export errors, e.g. cdpproxy.Logger
+type Logger interface {
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// stdLogger logs to the standard logger
+type stdLogger struct{}
+
+func (stdLogger) Warnf(format string, args ...interface{})  { log.Printf(format, args...) }
+func (stdLogger) Errorf(format string, args ...interface{}) { log.Printf(format, args...) }
+
+// Exporter batches the ended spans and posts them to the collector
+type Exporter struct {
+	// Endpoint is the collector's traces URL, e.g. http://localhost:4318/v1/traces
+	Endpoint    string
+	ServiceName string
+	Client      *http.Client
+	// Logger defaults to the standard logger, to be set before the first span ends
+	Logger Logger
+
+	once     sync.Once
+	ch       chan span
+	flushc   chan chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+const (
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+// NewExporter returns the exporter posting to the endpoint, started with the first span ended
+func NewExporter(endpoint, serviceName string) *Exporter {
+	return &Exporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *Exporter) init() {
+	if e.Logger == nil {
+		e.Logger = stdLogger{}
+	}
+	e.ch = make(chan span, 4*batchSize)
+	e.flushc = make(chan chan struct{})
+	e.done = make(chan struct{})
+	go e.loop()
+}
+
+// Start starts a span, the child of the parent if valid, the root of a new trace otherwise.
+// The nil exporter starts nil spans.
+func (e *Exporter) Start(name string, kind SpanKind, parent SpanContext, t time.Time) *Span {
+	if e == nil {
+		return nil
+	}
+
+	s := &Span{exp: e, name: name, kind: kind, start: t, parent: parent}
+	if parent.IsValid() {
+		s.sc.TraceID, s.sc.Flags = parent.TraceID, parent.Flags
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Flags = flagSampled
+	}
+	rand.Read(s.sc.SpanID[:])
+	return s
+}
+
+func (e *Exporter) queue(sp span) {
+	e.once.Do(e.init)
+	select {
+	case e.ch <- sp:
+	case <-e.done:
+	default:
+		e.Logger.Warnf("otlp: queue full, dropping span=%q", sp.Name)
+	}
+}
+
+func (e *Exporter) loop() {
+	var (
+		batch = make([]span, 0, batchSize)
+		tick  = time.NewTicker(flushInterval)
+	)
+	defer tick.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			e.Logger.Errorf("otlp: export: endpoint=%q spans=%d error=%q", e.Endpoint, len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case sp := <-e.ch:
+			if batch = append(batch, sp); len(batch) >= batchSize {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		case ack := <-e.flushc:
+			for len(e.ch) > 0 {
+				batch = append(batch, <-e.ch)
+			}
+			flush()
+			close(ack)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// Shutdown exports the queued spans and stops the exporter
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.once.Do(e.init)
+
+	var ack = make(chan struct{})
+	select {
+	case e.flushc <- ack:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer e.stopOnce.Do(func() { close(e.done) })
+
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Exporter) export(spans []span) error {
+	var req = exportRequest{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: encodeAttrs([]keyValue{{"service.name", e.ServiceName}})},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "github.com/gmarik/cdp-proxy"},
+			Spans: spans,
+		}},
+	}}}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := e.Client.Post(e.Endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// the OTLP/JSON encoding of the ExportTraceServiceRequest
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/collector/trace/v1/trace_service.proto
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              SpanKind    `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes"`
+	Events            []spanEvent `json:"events"`
+	Status            *status     `json:"status,omitempty"`
+}
+
+type spanEvent struct {
+	TimeUnixNano string      `json:"timeUnixNano"`
+	Name         string      `json:"name"`
+	Attributes   []attribute `json:"attributes"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	key   string
+	value interface{}
+}
+
+type attribute struct {
+	Key   string         `json:"key"`
+	Value attributeValue `json:"value"`
+}
+
+type attributeValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func encodeAttrs(kvs []keyValue) []attribute {
+	var attrs = make([]attribute, 0, len(kvs))
+	for _, kv := range kvs {
+		var v attributeValue
+		switch x := kv.value.(type) {
+		case string:
+			v.StringValue = &x
+		case bool:
+			v.BoolValue = &x
+		case int:
+			s := strconv.Itoa(x)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		attrs = append(attrs, attribute{Key: kv.key, Value: v})
+	}
+	return attrs
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,274 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collector stands in for the OTLP/HTTP collector, passing the received requests on
+type collector struct {
+	*httptest.Server
+	reqs chan exportRequest
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{reqs: make(chan exportRequest, 100)}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("collector: method=%q content-type=%q", r.Method, r.Header.Get("Content-Type"))
+		}
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector: decode: error=%q", err)
+		}
+		c.reqs <- req
+	}))
+	return c
+}
+
+func (c *collector) next(t *testing.T) exportRequest {
+	t.Helper()
+	select {
+	case req := <-c.reqs:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector: no export")
+		return exportRequest{}
+	}
+}
+
+func spansOf(req exportRequest) []span {
+	var spans []span
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			spans = append(spans, ss.Spans...)
+		}
+	}
+	return spans
+}
+
+// testLogger records the logged lines
+type testLogger struct{ lines chan string }
+
+func (l testLogger) Warnf(format string, args ...interface{}) {
+	l.lines <- fmt.Sprintf(format, args...)
+}
+func (l testLogger) Errorf(format string, args ...interface{}) {
+	l.lines <- fmt.Sprintf(format, args...)
+}
+
+func TestExporter_payload(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+	exp := NewExporter(c.URL, "test-service")
+
+	var (
+		start  = time.Unix(1, 0)
+		parent = SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}, Flags: flagSampled}
+		s      = exp.Start("HTTP GET", SpanKindClient, parent, start)
+	)
+	s.SetAttr("http.method", "GET")
+	s.SetAttr("http.status_code", 502)
+	s.SetAttr("reused", true)
+	s.AddEvent("ttfb", start.Add(time.Millisecond), "error", "")
+	s.SetError("502 Bad Gateway")
+	s.End(start.Add(time.Second))
+	s.End(start.Add(2 * time.Second)) // once
+
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := c.next(t)
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf("resourceSpans=%d", len(req.ResourceSpans))
+	}
+	if got := req.ResourceSpans[0].Resource.Attributes; len(got) != 1 || got[0].Key != "service.name" || *got[0].Value.StringValue != "test-service" {
+		t.Errorf("resource attributes=%+v", got)
+	}
+
+	spans := spansOf(req)
+	if len(spans) != 1 {
+		t.Fatalf("spans=%d", len(spans))
+	}
+	sp := spans[0]
+	if sp.TraceID != "01000000000000000000000000000000" || sp.ParentSpanID != "0200000000000000" {
+		t.Errorf("traceId=%q parentSpanId=%q", sp.TraceID, sp.ParentSpanID)
+	}
+	if len(sp.SpanID) != 16 || sp.SpanID == sp.ParentSpanID {
+		t.Errorf("spanId=%q", sp.SpanID)
+	}
+	if sp.Name != "HTTP GET" || sp.Kind != SpanKindClient {
+		t.Errorf("name=%q kind=%d", sp.Name, sp.Kind)
+	}
+	if sp.StartTimeUnixNano != "1000000000" || sp.EndTimeUnixNano != "2000000000" {
+		t.Errorf("start=%q end=%q", sp.StartTimeUnixNano, sp.EndTimeUnixNano)
+	}
+	if len(sp.Attributes) != 3 ||
+		*sp.Attributes[0].Value.StringValue != "GET" ||
+		*sp.Attributes[1].Value.IntValue != "502" ||
+		!*sp.Attributes[2].Value.BoolValue {
+		t.Errorf("attributes=%+v", sp.Attributes)
+	}
+	if len(sp.Events) != 1 || sp.Events[0].Name != "ttfb" || sp.Events[0].TimeUnixNano != "1001000000" {
+		t.Errorf("events=%+v", sp.Events)
+	}
+	if sp.Status == nil || sp.Status.Code != 2 || sp.Status.Message != "502 Bad Gateway" {
+		t.Errorf("status=%+v", sp.Status)
+	}
+}
+
+func TestExporter_batching(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+	exp := NewExporter(c.URL, "test-service")
+
+	for i := 0; i < batchSize+1; i++ {
+		exp.Start(fmt.Sprint("span-", i), SpanKindInternal, SpanContext{}, time.Now()).End(time.Now())
+	}
+
+	// the full batch goes out without waiting for the flush interval
+	if got := len(spansOf(c.next(t))); got != batchSize {
+		t.Errorf("first batch: spans=%d, want %d", got, batchSize)
+	}
+	select {
+	case req := <-c.reqs:
+		t.Errorf("exported before the flush: spans=%d", len(spansOf(req)))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(spansOf(c.next(t))); got != 1 {
+		t.Errorf("last batch: spans=%d, want 1", got)
+	}
+}
+
+func TestExporter_Shutdown(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+	exp := NewExporter(c.URL, "test-service")
+
+	for i := 0; i < 3; i++ {
+		exp.Start("span", SpanKindInternal, SpanContext{}, time.Now()).End(time.Now())
+	}
+	// not sampled
+	exp.Start("span", SpanKindInternal, SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{1}}, time.Now()).End(time.Now())
+
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the queued spans are exported by the time Shutdown returns
+	select {
+	case req := <-c.reqs:
+		if got := len(spansOf(req)); got != 3 {
+			t.Errorf("spans=%d, want 3", got)
+		}
+	default:
+		t.Fatal("Shutdown returned before the export")
+	}
+
+	// the spans ended after the shutdown are dropped
+	exp.Start("late", SpanKindInternal, SpanContext{}, time.Now()).End(time.Now())
+	select {
+	case req := <-c.reqs:
+		t.Errorf("exported after the shutdown: spans=%d", len(spansOf(req)))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestExporter_Shutdown_empty(t *testing.T) {
+	exp := NewExporter("http://127.0.0.1:0/v1/traces", "test-service")
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var nilExp *Exporter
+	nilExp.Start("span", SpanKindInternal, SpanContext{}, time.Now()).End(time.Now())
+	if err := nilExp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExporter_Logger(t *testing.T) {
+	var (
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		logger = testLogger{lines: make(chan string, 10)}
+	)
+	defer srv.Close()
+
+	exp := NewExporter(srv.URL, "test-service")
+	exp.Logger = logger
+	exp.Start("span", SpanKindInternal, SpanContext{}, time.Now()).End(time.Now())
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case line := <-logger.lines:
+		if !strings.HasPrefix(line, "otlp: export: ") || !strings.Contains(line, "503") {
+			t.Errorf("logged %q", line)
+		}
+	default:
+		t.Fatal("export error not logged")
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name    string
+		in      string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"future version", "01-" + traceID + "-" + spanID + "-01", true, true},
+		{"future version trailing fields", "01-" + traceID + "-" + spanID + "-01-what-the-future-holds", true, true},
+		{"version 00 trailing fields", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"future version trailing garbage", "01-" + traceID + "-" + spanID + "-01extra", false, false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"not hex", "00-" + strings.Replace(traceID, "4", "x", 1) + "-" + spanID + "-01", false, false},
+		{"short", "00-" + traceID + "-" + spanID + "-1", false, false},
+		{"separators", "00_" + traceID + "_" + spanID + "_01", false, false},
+		{"empty", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.in)
+			if ok != tt.ok {
+				t.Fatalf("ParseTraceparent(%q) ok=%v, want %v", tt.in, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.IsSampled() != tt.sampled {
+				t.Errorf("sampled=%v, want %v", sc.IsSampled(), tt.sampled)
+			}
+			if got := fmt.Sprintf("%x-%x", sc.TraceID, sc.SpanID); got != traceID+"-"+spanID {
+				t.Errorf("ids=%q", got)
+			}
+		})
+	}
+
+	// round trip
+	sc, _ := ParseTraceparent("00-" + traceID + "-" + spanID + "-01")
+	if got := sc.Traceparent(); got != "00-"+traceID+"-"+spanID+"-01" {
+		t.Errorf("Traceparent()=%q", got)
+	}
+}
@@ -0,0 +1,325 @@
+// Package fakedriver is an in-memory database/sql driver to try the tracing out without a database.
+// It knows just enough SQL:
+//
+//	CREATE TABLE t (a, b)
+//	INSERT INTO t VALUES (?, 'x'), (1, NULL)
+//	SELECT * FROM t [WHERE a = ?]
+//	SELECT a, b FROM t [WHERE a = ?]
+//	DELETE FROM t [WHERE a = ?]
+//
+// The connections of the same DSN share the tables; the transactions don't isolate nor roll back anything.
+package fakedriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Driver opens the in-memory databases, named after the DSNs
+type Driver struct {
+	mu  sync.Mutex
+	dbs map[string]*db
+}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dbs == nil {
+		d.dbs = make(map[string]*db)
+	}
+	if d.dbs[dsn] == nil {
+		d.dbs[dsn] = &db{tables: make(map[string]*table)}
+	}
+	return &conn{db: d.dbs[dsn]}, nil
+}
+
+type db struct {
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+type table struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (t *table) column(name string) (int, error) {
+	for i, c := range t.columns {
+		if c == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("fakedriver: no such column: %s", name)
+}
+
+type conn struct {
+	db *db
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{c: c, query: query}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.exec(query, values(args))
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.query(query, values(args))
+}
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.exec(s.query, args)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.query(s.query, args)
+}
+
+var (
+	createRe = regexp.MustCompile(`(?is)^\s*CREATE\s+TABLE\s+(\w+)\s*\((.*)\)\s*;?\s*$`)
+	insertRe = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+(\w+)\s+VALUES\s*(.*?)\s*;?\s*$`)
+	selectRe = regexp.MustCompile(`(?is)^\s*SELECT\s+(.+?)\s+FROM\s+(\w+)(?:\s+WHERE\s+(\w+)\s*=\s*(.+?))?\s*;?\s*$`)
+	deleteRe = regexp.MustCompile(`(?is)^\s*DELETE\s+FROM\s+(\w+)(?:\s+WHERE\s+(\w+)\s*=\s*(.+?))?\s*;?\s*$`)
+	tupleRe  = regexp.MustCompile(`\(([^()]*)\)`)
+)
+
+func (c *conn) exec(query string, args []driver.Value) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	var p = &params{args: args}
+
+	if m := createRe.FindStringSubmatch(query); m != nil {
+		if c.db.tables[m[1]] != nil {
+			return nil, fmt.Errorf("fakedriver: table %s already exists", m[1])
+		}
+		c.db.tables[m[1]] = &table{columns: split(m[2])}
+		return driver.ResultNoRows, nil
+	}
+	if m := insertRe.FindStringSubmatch(query); m != nil {
+		t, err := c.db.table(m[1])
+		if err != nil {
+			return nil, err
+		}
+		var n int64
+		for _, tuple := range tupleRe.FindAllStringSubmatch(m[2], -1) {
+			var row []driver.Value
+			for _, s := range split(tuple[1]) {
+				v, err := p.value(s)
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, v)
+			}
+			if len(row) != len(t.columns) {
+				return nil, fmt.Errorf("fakedriver: %d values for %d columns", len(row), len(t.columns))
+			}
+			t.rows = append(t.rows, row)
+			n++
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("fakedriver: no values: %q", m[2])
+		}
+		return result{lastID: int64(len(t.rows)), affected: n}, nil
+	}
+	if m := deleteRe.FindStringSubmatch(query); m != nil {
+		t, err := c.db.table(m[1])
+		if err != nil {
+			return nil, err
+		}
+		match, err := where(t, p, m[2], m[3])
+		if err != nil {
+			return nil, err
+		}
+		var kept [][]driver.Value
+		for _, row := range t.rows {
+			if !match(row) {
+				kept = append(kept, row)
+			}
+		}
+		n := len(t.rows) - len(kept)
+		t.rows = kept
+		return driver.RowsAffected(n), nil
+	}
+	if selectRe.MatchString(query) {
+		return nil, errors.New("fakedriver: SELECT returns rows, use Query")
+	}
+	return nil, fmt.Errorf("fakedriver: unsupported statement: %q", query)
+}
+
+func (c *conn) query(query string, args []driver.Value) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	m := selectRe.FindStringSubmatch(query)
+	if m == nil {
+		return nil, fmt.Errorf("fakedriver: unsupported query: %q", query)
+	}
+	t, err := c.db.table(m[2])
+	if err != nil {
+		return nil, err
+	}
+	match, err := where(t, &params{args: args}, m[3], m[4])
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		columns = t.columns
+		indexes []int
+	)
+	if strings.TrimSpace(m[1]) == "*" {
+		for i := range t.columns {
+			indexes = append(indexes, i)
+		}
+	} else {
+		columns = split(m[1])
+		for _, name := range columns {
+			i, err := t.column(name)
+			if err != nil {
+				return nil, err
+			}
+			indexes = append(indexes, i)
+		}
+	}
+
+	var rs = &rows{columns: columns}
+	for _, row := range t.rows {
+		if !match(row) {
+			continue
+		}
+		var r = make([]driver.Value, len(indexes))
+		for i, j := range indexes {
+			r[i] = row[j]
+		}
+		rs.rows = append(rs.rows, r)
+	}
+	return rs, nil
+}
+
+func (d *db) table(name string) (*table, error) {
+	if t := d.tables[name]; t != nil {
+		return t, nil
+	}
+	return nil, fmt.Errorf("fakedriver: no such table: %s", name)
+}
+
+// where matches the rows by the column's value, all of them without the column
+func where(t *table, p *params, column, value string) (func([]driver.Value) bool, error) {
+	if column == "" {
+		return func([]driver.Value) bool { return true }, nil
+	}
+	i, err := t.column(column)
+	if err != nil {
+		return nil, err
+	}
+	v, err := p.value(value)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []driver.Value) bool { return equal(row[i], v) }, nil
+}
+
+func equal(a, b driver.Value) bool {
+	if ab, ok := a.([]byte); ok {
+		a = string(ab)
+	}
+	if bb, ok := b.([]byte); ok {
+		b = string(bb)
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// params are the positional args consumed by the placeholders
+type params struct {
+	args []driver.Value
+	n    int
+}
+
+// value is the literal or the next arg for a `?`
+func (p *params) value(s string) (driver.Value, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "?":
+		if p.n >= len(p.args) {
+			return nil, fmt.Errorf("fakedriver: missing arg %d", p.n+1)
+		}
+		p.n++
+		return p.args[p.n-1], nil
+	case strings.EqualFold(s, "NULL"):
+		return nil, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("fakedriver: unsupported value: %q", s)
+}
+
+func split(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		items = append(items, strings.TrimSpace(item))
+	}
+	return items
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	var vs = make([]driver.Value, len(args))
+	for i, a := range args {
+		vs[i] = a.Value
+	}
+	return vs
+}
+
+type result struct {
+	lastID, affected int64
+}
+
+func (r result) LastInsertId() (int64, error) { return r.lastID, nil }
+func (r result) RowsAffected() (int64, error) { return r.affected, nil }
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,469 @@
+// Package sql traces the database/sql queries with the Tracer of package http, each query as a Network entry:
+// the SQL as the URL and the post data, the args as the headers, the rows as the JSON body
+// and the exec results as the response headers.
+//
+//	sqlx.Register("traced-postgres", sessions, &pq.Driver{})
+//	db, err := sql.Open("traced-postgres", dsn)
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	httpx "github.com/gmarik/cdp-proxy/http"
+)
+
+// Register registers the driver, traced into the trace, as the name to sql.Open
+func Register(name string, trace httpx.Tracer, d driver.Driver) {
+	sql.Register(name, Wrap(name, trace, d))
+}
+
+// Wrap traces the queries of the driver's connections, the name being the host of the entries' URLs
+func Wrap(name string, trace httpx.Tracer, d driver.Driver) driver.Driver {
+	return &tracedDriver{Driver: d, t: &tracer{name: name, trace: trace}}
+}
+
+// WrapConnector traces the queries of the connector's connections, to sql.OpenDB
+func WrapConnector(name string, trace httpx.Tracer, c driver.Connector) driver.Connector {
+	return &connector{Connector: c, t: &tracer{name: name, trace: trace}}
+}
+
+type tracedDriver struct {
+	driver.Driver
+	t *tracer
+}
+
+func (d *tracedDriver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, t: d.t}, nil
+}
+
+type connector struct {
+	driver.Connector
+	t *tracer
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cc, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: cc, t: c.t}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &tracedDriver{Driver: c.Connector.Driver(), t: c.t}
+}
+
+// tracer starts the entries of the queries
+type tracer struct {
+	name  string
+	trace httpx.Tracer
+}
+
+// call is a query in progress
+type call struct {
+	trace httpx.Tracer
+	reqID string
+	req   *http.Request
+	once  sync.Once
+}
+
+func (t *tracer) start(ctx context.Context, method, query string, args []driver.NamedValue) *call {
+	r := &http.Request{
+		Method:     method,
+		URL:        &url.URL{Scheme: "sql", Host: t.name, Path: "/" + summary(query)},
+		Host:       t.name,
+		Proto:      "SQL",
+		Header:     make(http.Header),
+		RequestURI: query,
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/sql")
+	for _, a := range args {
+		var name = strconv.Itoa(a.Ordinal)
+		if a.Name != "" {
+			name = a.Name
+		}
+		r.Header.Set("Arg-"+name, formatValue(a.Value))
+	}
+	if query != "" {
+		r.ContentLength = int64(len(query))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(query)), nil
+		}
+	}
+
+	return &call{trace: t.trace, req: r, reqID: t.trace.RequestWillBeSent(r)}
+}
+
+func (c *call) response() *http.Response {
+	return &http.Response{
+		Request:    c.req,
+		StatusCode: http.StatusOK,
+		Status:     "OK",
+		Proto:      c.req.Proto,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+	}
+}
+
+// done finishes the call, failed if err isn't nil
+func (c *call) done(err error) {
+	c.once.Do(func() {
+		if err != nil {
+			c.trace.LoadingFailed(c.reqID, c.req, err)
+			return
+		}
+		re := c.response()
+		c.trace.ResponseReceived(c.reqID, re)
+		c.trace.LoadingFinished(c.reqID, re)
+	})
+}
+
+// result finishes the exec with its result, the rows affected and the last insert ID as the headers and the body
+func (c *call) result(res driver.Result, err error) (driver.Result, error) {
+	if err != nil {
+		c.done(err)
+		return res, err
+	}
+	c.once.Do(func() {
+		re := c.response()
+		var v = make(map[string]int64)
+		if n, err := res.RowsAffected(); err == nil {
+			re.Header.Set("Rows-Affected", strconv.FormatInt(n, 10))
+			v["rowsAffected"] = n
+		}
+		if id, err := res.LastInsertId(); err == nil {
+			re.Header.Set("Last-Insert-Id", strconv.FormatInt(id, 10))
+			v["lastInsertId"] = id
+		}
+		var body []byte
+		if len(v) > 0 {
+			body, _ = json.Marshal(v)
+			body = append(body, '\n')
+			re.ContentLength = int64(len(body))
+		}
+
+		c.trace.ResponseReceived(c.reqID, re)
+		if len(body) > 0 {
+			c.trace.DataReceived(c.reqID, body)
+		}
+		c.trace.LoadingFinished(c.reqID, re)
+	})
+	return res, nil
+}
+
+// rows receives the response with the columns, the rows are reported as read
+func (c *call) rows(rs driver.Rows, err error) (driver.Rows, error) {
+	if err != nil {
+		c.done(err)
+		return rs, err
+	}
+	re := c.response()
+	re.Header.Set("Columns", strings.Join(rs.Columns(), ", "))
+	c.trace.ResponseReceived(c.reqID, re)
+	return &rows{Rows: rs, c: c, re: re}, nil
+}
+
+type conn struct {
+	driver.Conn
+	t *tracer
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		s   driver.Stmt
+		err error
+	)
+	if pc, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		s, err = pc.PrepareContext(ctx, query)
+	} else {
+		s, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: s, query: query, t: c.t}, nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var (
+		call = c.t.start(ctx, "BEGIN", "BEGIN", nil)
+		tx   driver.Tx
+		err  error
+	)
+	if bc, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = bc.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	call.done(err)
+	if err != nil {
+		return nil, err
+	}
+	return &transaction{Tx: tx, ctx: ctx, t: c.t}, nil
+}
+
+// ExecContext records the exec once the driver has done it:
+// the driver may skip it with driver.ErrSkip, for database/sql to prepare the statement, recorded instead
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var (
+		res driver.Result
+		err error
+	)
+	switch ec := c.Conn.(type) {
+	case driver.ExecerContext:
+		res, err = ec.ExecContext(ctx, query, args)
+	case driver.Execer:
+		values, verr := namedValues(args)
+		if verr != nil {
+			return nil, verr
+		}
+		res, err = ec.Exec(query, values)
+	default:
+		// database/sql prepares the statement instead
+		return nil, driver.ErrSkip
+	}
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	return c.t.start(ctx, "EXEC", query, args).result(res, err)
+}
+
+// QueryContext records the query once the driver has started it, see ExecContext; the rows are reported as read
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	var (
+		rs  driver.Rows
+		err error
+	)
+	switch qc := c.Conn.(type) {
+	case driver.QueryerContext:
+		rs, err = qc.QueryContext(ctx, query, args)
+	case driver.Queryer:
+		values, verr := namedValues(args)
+		if verr != nil {
+			return nil, verr
+		}
+		rs, err = qc.Query(query, values)
+	default:
+		// database/sql prepares the statement instead
+		return nil, driver.ErrSkip
+	}
+	if err == driver.ErrSkip {
+		return nil, err
+	}
+	return c.t.start(ctx, "QUERY", query, args).rows(rs, err)
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if sr, ok := c.Conn.(driver.SessionResetter); ok {
+		return sr.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	driver.Stmt
+	query string
+	t     *tracer
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	call := s.t.start(context.Background(), "EXEC", s.query, values(args))
+	return call.result(s.Stmt.Exec(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	call := s.t.start(context.Background(), "QUERY", s.query, values(args))
+	return call.rows(s.Stmt.Query(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	if ec, ok := s.Stmt.(driver.StmtExecContext); ok {
+		call := s.t.start(ctx, "EXEC", s.query, args)
+		return call.result(ec.ExecContext(ctx, args))
+	}
+	vs, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Exec(vs)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	if qc, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		call := s.t.start(ctx, "QUERY", s.query, args)
+		return call.rows(qc.QueryContext(ctx, args))
+	}
+	vs, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(vs)
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nvc, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return nvc.CheckNamedValue(nv)
+	}
+	if cc, ok := s.Stmt.(driver.ColumnConverter); ok {
+		v, err := cc.ColumnConverter(nv.Ordinal - 1).ConvertValue(nv.Value)
+		nv.Value = v
+		return err
+	}
+	return driver.ErrSkip
+}
+
+type transaction struct {
+	driver.Tx
+	ctx context.Context
+	t   *tracer
+}
+
+func (tx *transaction) Commit() error {
+	call := tx.t.start(tx.ctx, "COMMIT", "COMMIT", nil)
+	err := tx.Tx.Commit()
+	call.done(err)
+	return err
+}
+
+func (tx *transaction) Rollback() error {
+	call := tx.t.start(tx.ctx, "ROLLBACK", "ROLLBACK", nil)
+	err := tx.Tx.Rollback()
+	call.done(err)
+	return err
+}
+
+// rows reports each row as a JSON line, finishing at the end or the Close
+type rows struct {
+	driver.Rows
+	c    *call
+	re   *http.Response
+	size int64
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		var row = make([]interface{}, len(dest))
+		for i, v := range dest {
+			row[i] = jsonValue(v)
+		}
+		line, merr := json.Marshal(row)
+		if merr != nil {
+			line = []byte(strconv.Quote(merr.Error()))
+		}
+		line = append(line, '\n')
+		r.size += int64(len(line))
+		r.c.trace.DataReceived(r.c.reqID, line)
+	case err == io.EOF:
+		r.finish(nil)
+	default:
+		r.finish(err)
+	}
+	return err
+}
+
+func (r *rows) Close() error {
+	err := r.Rows.Close()
+	r.finish(err)
+	return err
+}
+
+func (r *rows) finish(err error) {
+	r.c.once.Do(func() {
+		if err != nil {
+			r.c.trace.LoadingFailed(r.c.reqID, r.c.req, err)
+			return
+		}
+		r.re.ContentLength = r.size
+		r.c.trace.LoadingFinished(r.c.reqID, r.re)
+	})
+}
+
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	var vs = make([]driver.Value, len(args))
+	for i, a := range args {
+		if a.Name != "" {
+			return nil, fmt.Errorf("sql: driver doesn't support the named args: %q", a.Name)
+		}
+		vs[i] = a.Value
+	}
+	return vs, nil
+}
+
+func values(args []driver.Value) []driver.NamedValue {
+	var nvs = make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return nvs
+}
+
+// summary is the query on a line, shortened to be the URL's path
+func summary(query string) string {
+	s := strings.Join(strings.Fields(query), " ")
+	if len(s) > 100 {
+		s = s[:100] + "..."
+	}
+	return s
+}
+
+func jsonValue(v driver.Value) interface{} {
+	if b, ok := v.([]byte); ok && utf8.Valid(b) {
+		return string(b)
+	}
+	return v
+}
+
+func formatValue(v driver.Value) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return strconv.Quote(x)
+	case []byte:
+		if utf8.Valid(x) {
+			return strconv.Quote(string(x))
+		}
+		return fmt.Sprintf("0x%x", x)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,339 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gmarik/cdp-proxy/sql/fakedriver"
+)
+
+// recorder is the Tracer keeping the entries
+type recorder struct {
+	mu      sync.Mutex
+	entries []*entry
+}
+
+type entry struct {
+	req    *http.Request
+	re     *http.Response
+	chunks []string
+	done   bool
+	err    error
+}
+
+func (rec *recorder) RequestWillBeSent(r *http.Request) string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entries = append(rec.entries, &entry{req: r})
+	return fmt.Sprint(len(rec.entries) - 1)
+}
+
+func (rec *recorder) entry(reqID string) *entry {
+	var i int
+	fmt.Sscan(reqID, &i)
+	return rec.entries[i]
+}
+
+func (rec *recorder) ResponseReceived(reqID string, re *http.Response) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entry(reqID).re = re
+}
+
+func (rec *recorder) DataReceived(reqID string, p []byte) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	e := rec.entry(reqID)
+	e.chunks = append(e.chunks, strings.TrimSpace(string(p)))
+}
+
+func (rec *recorder) LoadingFinished(reqID string, re *http.Response) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entry(reqID).done = true
+}
+
+func (rec *recorder) LoadingFailed(reqID string, r *http.Request, err error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	e := rec.entry(reqID)
+	e.done, e.err = true, err
+}
+
+// reset returns the entries recorded so far, forgetting them
+func (rec *recorder) reset() []entry {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var entries []entry
+	for _, e := range rec.entries {
+		entries = append(entries, *e)
+	}
+	rec.entries = nil
+	return entries
+}
+
+// methods lists the entries as `METHOD sql`, the failed ones with the ! suffix
+func methods(entries []entry) []string {
+	var ms []string
+	for _, e := range entries {
+		m := e.req.Method + " " + e.req.RequestURI
+		if e.err != nil {
+			m += "!"
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
+
+// fakeConnector opens the fake DSN's connections, wrapped with wrap if not nil
+type fakeConnector struct {
+	dsn  string
+	d    *fakedriver.Driver
+	wrap func(driver.Conn) driver.Conn
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.d.Open(c.dsn)
+	if err != nil || c.wrap == nil {
+		return conn, err
+	}
+	return c.wrap(conn), nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver { return c.d }
+
+func open(t *testing.T, wrap func(driver.Conn) driver.Conn) (*sql.DB, *recorder) {
+	rec := new(recorder)
+	db := sql.OpenDB(WrapConnector("fake", rec, &fakeConnector{dsn: t.Name(), d: new(fakedriver.Driver), wrap: wrap}))
+	if _, err := db.Exec("CREATE TABLE users (id, name)"); err != nil {
+		t.Fatal(err)
+	}
+	rec.reset()
+	return db, rec
+}
+
+func TestExec(t *testing.T) {
+	db, rec := open(t, nil)
+	defer db.Close()
+
+	res, err := db.Exec("INSERT INTO users VALUES (?, ?), (2, 'bob')", 1, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("rowsAffected=%d", n)
+	}
+
+	entries := rec.reset()
+	if len(entries) != 1 {
+		t.Fatalf("entries=%q", methods(entries))
+	}
+	e := entries[0]
+	if u := e.req.URL; e.req.Method != "EXEC" || u.Scheme != "sql" || u.Host != "fake" || u.Path != "/INSERT INTO users VALUES (?, ?), (2, 'bob')" {
+		t.Errorf("method=%q url=%q", e.req.Method, e.req.URL)
+	}
+	if got := []string{e.req.Header.Get("Arg-1"), e.req.Header.Get("Arg-2")}; got[0] != "1" || got[1] != `"alice"` {
+		t.Errorf("args=%q", got)
+	}
+	if body, _ := e.req.GetBody(); body == nil {
+		t.Error("no post data")
+	} else if data, _ := ioutil.ReadAll(body); string(data) != "INSERT INTO users VALUES (?, ?), (2, 'bob')" {
+		t.Errorf("post data=%q", data)
+	}
+	if e.re == nil || e.re.Header.Get("Rows-Affected") != "2" || e.re.Header.Get("Last-Insert-Id") != "2" {
+		t.Fatalf("response=%+v", e.re)
+	}
+	if want := []string{`{"lastInsertId":2,"rowsAffected":2}`}; fmt.Sprint(e.chunks) != fmt.Sprint(want) {
+		t.Errorf("chunks=%q, want %q", e.chunks, want)
+	}
+	if !e.done || e.err != nil {
+		t.Errorf("done=%v error=%v", e.done, e.err)
+	}
+
+	if _, err := db.Exec("INSERT INTO nope VALUES (1)"); err == nil {
+		t.Fatal("no error")
+	}
+	if got := methods(rec.reset()); fmt.Sprint(got) != "[EXEC INSERT INTO nope VALUES (1)!]" {
+		t.Errorf("entries=%q", got)
+	}
+}
+
+func TestQuery_rows(t *testing.T) {
+	db, rec := open(t, nil)
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users VALUES (1, 'alice'), (2, 'bob'), (1, 'carol')"); err != nil {
+		t.Fatal(err)
+	}
+	rec.reset()
+
+	rows, err := db.Query("SELECT name, id FROM users WHERE id = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for rows.Next() {
+		var (
+			name string
+			id   int64
+		)
+		if err := rows.Scan(&name, &id); err != nil {
+			t.Fatal(err)
+		}
+		// the rows are reported as read
+		if e := rec.entries[0]; len(e.chunks) != len(names)+1 || e.done {
+			t.Errorf("row %d: chunks=%d done=%v", len(names), len(e.chunks), e.done)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	entries := rec.reset()
+	if len(entries) != 1 {
+		t.Fatalf("entries=%q", methods(entries))
+	}
+	e := entries[0]
+	if e.req.Method != "QUERY" || e.req.Header.Get("Arg-1") != "1" {
+		t.Errorf("method=%q args=%v", e.req.Method, e.req.Header)
+	}
+	if e.re == nil || e.re.Header.Get("Columns") != "name, id" {
+		t.Fatalf("response=%+v", e.re)
+	}
+	if want := []string{`["alice",1]`, `["carol",1]`}; fmt.Sprint(e.chunks) != fmt.Sprint(want) {
+		t.Errorf("chunks=%q, want %q", e.chunks, want)
+	}
+	if !e.done || e.err != nil || e.re.ContentLength != int64(len(`["alice",1]`+"\n"+`["carol",1]`+"\n")) {
+		t.Errorf("done=%v error=%v contentLength=%d", e.done, e.err, e.re.ContentLength)
+	}
+
+	// closed before the end
+	rows, err = db.Query("SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Next()
+	rows.Close()
+	if e := rec.reset()[0]; !e.done || e.err != nil || len(e.chunks) != 1 {
+		t.Errorf("closed early: chunks=%q done=%v error=%v", e.chunks, e.done, e.err)
+	}
+
+	if _, err := db.Query("SELECT * FROM nope"); err == nil {
+		t.Fatal("no error")
+	}
+	if got := methods(rec.reset()); fmt.Sprint(got) != "[QUERY SELECT * FROM nope!]" {
+		t.Errorf("entries=%q", got)
+	}
+}
+
+func TestTx(t *testing.T) {
+	db, rec := open(t, nil)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO users VALUES (1, 'alice')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[BEGIN BEGIN EXEC INSERT INTO users VALUES (1, 'alice') COMMIT COMMIT BEGIN BEGIN ROLLBACK ROLLBACK]"
+	entries := rec.reset()
+	if got := methods(entries); fmt.Sprint(got) != want {
+		t.Errorf("entries=%q, want %s", got, want)
+	}
+	for _, e := range entries {
+		if !e.done || e.err != nil {
+			t.Errorf("%s: done=%v error=%v", e.req.Method, e.done, e.err)
+		}
+	}
+}
+
+// skipConn skips the execs and the queries, e.g. like lib/pq does with the args, for database/sql to prepare them
+type skipConn struct {
+	driver.Conn
+}
+
+func (skipConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (skipConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func TestErrSkip(t *testing.T) {
+	db, rec := open(t, func(c driver.Conn) driver.Conn { return skipConn{c} })
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO users VALUES (?, 'alice')", 1); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT * FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+
+	// recorded once, by the prepared statements
+	entries := rec.reset()
+	if got := methods(entries); fmt.Sprint(got) != "[EXEC INSERT INTO users VALUES (?, 'alice') QUERY SELECT * FROM users]" {
+		t.Fatalf("entries=%q", got)
+	}
+	for _, e := range entries {
+		if !e.done || e.err != nil {
+			t.Errorf("%s: done=%v error=%v", e.req.Method, e.done, e.err)
+		}
+	}
+	if got := entries[0].req.Header.Get("Arg-1"); got != "1" {
+		t.Errorf("args=%q", got)
+	}
+	if got := entries[1].chunks; fmt.Sprint(got) != `[[1,"alice"]]` {
+		t.Errorf("chunks=%q", got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	rec := new(recorder)
+	Register("traced-fake", rec, new(fakedriver.Driver))
+
+	db, err := sql.Open("traced-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stmt, err := db.Prepare("CREATE TABLE t (a)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	if got := methods(rec.reset()); fmt.Sprint(got) != "[EXEC CREATE TABLE t (a)]" {
+		t.Errorf("entries=%q", got)
+	}
+}